@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// KeySet fetches an issuer's JSON Web Key Set and caches its signing keys. Keys are
+// refetched once TTL has passed, and early when a token names an unknown kid (the
+// issuer rotated its keys), at most once per MinRefreshInterval so tokens with bogus
+// kids can't hammer the issuer.
+type KeySet struct {
+	URL    string
+	Client *http.Client
+	// TTL bounds how long fetched keys are used; zero caches them until a kid miss.
+	TTL time.Duration
+	// MinRefreshInterval is the minimum time between two fetches.
+	MinRefreshInterval time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// NewKeySet returns a KeySet for the JWKS at url, fetched lazily on first use, with
+// a one hour TTL.
+func NewKeySet(url string, timeout time.Duration) *KeySet {
+	return &KeySet{
+		URL:                url,
+		Client:             &http.Client{Timeout: timeout},
+		TTL:                time.Hour,
+		MinRefreshInterval: 30 * time.Second,
+	}
+}
+
+// Key returns the signing key with the given kid. A token without a kid is accepted
+// when the set holds exactly one key.
+func (k *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	expired := k.TTL > 0 && time.Since(k.fetchedAt) >= k.TTL
+	if k.keys == nil || expired {
+		if err := k.refresh(ctx); err != nil && k.keys == nil {
+			return nil, err
+		} else if err != nil {
+			// Keep using the stale keys rather than failing every request.
+			log.Printf("WARN JWKS refresh failed, using cached keys: %v", err)
+		}
+	}
+	key, err := lookupKey(k.keys, kid)
+	if err != nil && time.Since(k.fetchedAt) >= k.MinRefreshInterval {
+		// Unknown kid: the issuer may have rotated keys since our last fetch.
+		if rerr := k.refresh(ctx); rerr != nil {
+			return nil, rerr
+		}
+		key, err = lookupKey(k.keys, kid)
+	}
+	return key, err
+}
+
+// refresh refetches the key set. fetchedAt moves even on failure so an unreachable
+// issuer is retried at most once per MinRefreshInterval.
+func (k *KeySet) refresh(ctx context.Context) error {
+	keys, err := k.fetch(ctx)
+	k.fetchedAt = time.Now()
+	if err != nil {
+		return err
+	}
+	k.keys = keys
+	return nil
+}
+
+// lookupKey picks kid out of keys.
+func lookupKey(keys map[string]crypto.PublicKey, kid string) (crypto.PublicKey, error) {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no signing key with kid %q", kid)
+	}
+	return key, nil
+}
+
+// jwk is the subset of RFC 7517 members needed for RSA and EC signature keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetch downloads and parses the key set, skipping keys it can't use for signatures.
+func (k *KeySet) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch JWKS: status %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decode JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		if key, err := j.publicKey(); err == nil {
+			keys[j.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS at %s has no usable signing keys", k.URL)
+	}
+	return keys, nil
+}
+
+// publicKey decodes an RSA or EC (P-256/P-384) JWK.
+func (j jwk) publicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := b64Int(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64Int(j.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, fmt.Errorf("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := b64Int(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64Int(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+}
+
+// b64Int decodes a base64url big-endian unsigned integer.
+func b64Int(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeySetRotationRefreshesOnce(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := iss.verifier()
+	v.Keys.MinRefreshInterval = 0
+	ctx := context.Background()
+	if _, err := v.Verify(ctx, iss.sign(t, "RS256", validClaims())); err != nil {
+		t.Fatal(err)
+	}
+	if n := iss.fetches.Load(); n != 1 {
+		t.Fatalf("fetches after first verify = %d, want 1", n)
+	}
+
+	iss.rotate(t, "k2")
+	rotated := iss.sign(t, "RS256", validClaims())
+	if _, err := v.Verify(ctx, rotated); err != nil {
+		t.Fatalf("token signed by rotated key rejected: %v", err)
+	}
+	if n := iss.fetches.Load(); n != 2 {
+		t.Fatalf("fetches after rotation = %d, want exactly one refresh (2)", n)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(ctx, rotated); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := iss.fetches.Load(); n != 2 {
+		t.Errorf("fetches after reusing the rotated key = %d, want 2", n)
+	}
+}
+
+func TestKeySetUnknownKidRateLimited(t *testing.T) {
+	iss := newTestIssuer(t)
+	keys := NewKeySet(iss.srv.URL, time.Second)
+	ctx := context.Background()
+	if _, err := keys.Key(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := keys.Key(ctx, "bogus"); err == nil {
+			t.Fatal("unknown kid accepted")
+		}
+	}
+	if n := iss.fetches.Load(); n != 1 {
+		t.Errorf("fetches = %d, want 1 (kid misses within MinRefreshInterval don't refetch)", n)
+	}
+}
+
+func TestKeySetTTL(t *testing.T) {
+	iss := newTestIssuer(t)
+	keys := NewKeySet(iss.srv.URL, time.Second)
+	keys.TTL = time.Millisecond
+	ctx := context.Background()
+	if _, err := keys.Key(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := keys.Key(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := iss.fetches.Load(); n != 2 {
+		t.Errorf("fetches = %d, want 2 (refetch after TTL)", n)
+	}
+}
+
+func TestKeySetKeepsStaleKeysWhenRefreshFails(t *testing.T) {
+	iss := newTestIssuer(t)
+	keys := NewKeySet(iss.srv.URL, time.Second)
+	keys.TTL = time.Millisecond
+	ctx := context.Background()
+	if _, err := keys.Key(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	iss.srv.Close()
+	time.Sleep(5 * time.Millisecond)
+	if _, err := keys.Key(ctx, "k1"); err != nil {
+		t.Errorf("stale key not used after failed refresh: %v", err)
+	}
+}
+
+func TestKeySetRejectsUnusableJWKS(t *testing.T) {
+	for name, body := range map[string]string{
+		"not JSON":     `<html>`,
+		"no keys":      `{"keys":[]}`,
+		"only HMAC":    `{"keys":[{"kty":"oct","kid":"a","k":"c2VjcmV0"}]}`,
+		"only enc":     `{"keys":[{"kty":"RSA","kid":"a","use":"enc","n":"` + base64.RawURLEncoding.EncodeToString([]byte{1, 2, 3}) + `","e":"AQAB"}]}`,
+		"bad EC curve": `{"keys":[{"kty":"EC","kid":"a","crv":"P-521","x":"AQ","y":"AQ"}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(body))
+			}))
+			defer srv.Close()
+			if _, err := NewKeySet(srv.URL, time.Second).Key(context.Background(), "a"); err == nil {
+				t.Error("Key succeeded")
+			}
+		})
+	}
+}
+
+func TestLookupKeyWithoutKid(t *testing.T) {
+	iss := newTestIssuer(t)
+	keys := NewKeySet(iss.srv.URL, time.Second)
+	// The test issuer publishes an RSA and an EC key, so an empty kid is ambiguous.
+	if _, err := keys.Key(context.Background(), ""); err == nil || !strings.Contains(err.Error(), "kid") {
+		t.Errorf("ambiguous empty kid: err = %v", err)
+	}
+}
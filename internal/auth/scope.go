@@ -0,0 +1,77 @@
+package auth
+
+import "strings"
+
+// Access is the kind of operation a SMART scope grants on a resource type.
+type Access string
+
+const (
+	Read  Access = "read"
+	Write Access = "write"
+)
+
+// DebugScope grants the troubleshooting operations (e.g. $validate-backend), which
+// expose raw backend records. It is an administrative scope, never a SMART one.
+const DebugScope = "admin/debug"
+
+// HasScope reports whether the claims include scope verbatim.
+func (c *Claims) HasScope(scope string) bool {
+	return contains(c.Scopes, scope)
+}
+
+// Allows reports whether the claims' scopes grant access to resourceType. It accepts
+// SMART v1 scopes (patient/Patient.read, user/*.write, patient/Patient.*) and v2
+// scopes (patient/Patient.rs, where r/s grant read and c/u/d grant write) in any of
+// the patient, user and system contexts.
+func (c *Claims) Allows(resourceType string, access Access) bool {
+	for _, scope := range c.Scopes {
+		if _, ok := grants(scope, resourceType, access); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PatientScoped reports whether any scope granting access to resourceType is in the
+// patient context, i.e. the grant only holds for the launch context patient.
+func (c *Claims) PatientScoped(resourceType string, access Access) bool {
+	for _, scope := range c.Scopes {
+		if ctx, ok := grants(scope, resourceType, access); ok && ctx == "patient" {
+			return true
+		}
+	}
+	return false
+}
+
+// grants reports whether scope grants access to resourceType, and in which context.
+func grants(scope, resourceType string, access Access) (ctx string, ok bool) {
+	ctx, rest, ok := strings.Cut(scope, "/")
+	if !ok || (ctx != "patient" && ctx != "user" && ctx != "system") {
+		return "", false
+	}
+	res, perm, ok := strings.Cut(rest, ".")
+	if !ok || (res != resourceType && res != "*") {
+		return "", false
+	}
+	return ctx, permits(perm, access)
+}
+
+// permits matches a scope's permission part against access.
+func permits(perm string, access Access) bool {
+	switch perm {
+	case "*", string(access):
+		return true
+	case "read", "write":
+		return false
+	}
+	// SMART v2 permission letters, e.g. "rs" or "cruds"; a query suffix (?category=)
+	// narrows the grant beyond what we can enforce, so it doesn't count.
+	if strings.Contains(perm, "?") {
+		return false
+	}
+	letters := "rs"
+	if access == Write {
+		letters = "cud"
+	}
+	return strings.ContainsAny(perm, letters)
+}
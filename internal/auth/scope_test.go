@@ -0,0 +1,56 @@
+package auth
+
+import "testing"
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		scope string
+		read  bool
+		write bool
+	}{
+		{"patient/Patient.read", true, false},
+		{"patient/Patient.write", false, true},
+		{"user/Patient.*", true, true},
+		{"system/*.read", true, false},
+		{"user/*.write", false, true},
+		{"patient/Patient.rs", true, false},
+		{"patient/Patient.cud", false, true},
+		{"patient/Patient.cruds", true, true},
+		{"patient/Patient.rs?category=x", false, false},
+		{"patient/Observation.read", false, false},
+		{"admin/Patient.read", false, false},
+		{"openid", false, false},
+		{"launch/patient", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			c := &Claims{Scopes: []string{tt.scope}}
+			if got := c.Allows("Patient", Read); got != tt.read {
+				t.Errorf("read = %v, want %v", got, tt.read)
+			}
+			if got := c.Allows("Patient", Write); got != tt.write {
+				t.Errorf("write = %v, want %v", got, tt.write)
+			}
+		})
+	}
+}
+
+func TestPatientScoped(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		want   bool
+	}{
+		{[]string{"patient/Patient.read"}, true},
+		{[]string{"patient/*.rs"}, true},
+		{[]string{"user/Patient.read"}, false},
+		{[]string{"user/Patient.read", "patient/Patient.read"}, true},
+		{[]string{"patient/Patient.write", "user/Patient.read"}, false},
+		{[]string{"patient/Observation.read"}, false},
+	}
+	for _, tt := range tests {
+		c := &Claims{Scopes: tt.scopes}
+		if got := c.PatientScoped("Patient", Read); got != tt.want {
+			t.Errorf("PatientScoped(%q) = %v, want %v", tt.scopes, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Package auth verifies SMART on FHIR bearer tokens: JWTs signed by a configured
+// issuer, checked against the issuer's JWKS.
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Claims are the parts of a validated access token the server acts on.
+type Claims struct {
+	Subject   string
+	Issuer    string
+	Audience  []string
+	Scopes    []string
+	Patient   string // SMART launch context, when the token is patient-scoped
+	ExpiresAt time.Time
+}
+
+// Verifier validates bearer tokens issued by Issuer.
+type Verifier struct {
+	Issuer string
+	// Audience, when set, must appear in the token's aud claim.
+	Audience string
+	Keys     *KeySet
+	// Leeway tolerates clock skew on exp/nbf.
+	Leeway time.Duration
+	// Now overrides the clock; nil uses time.Now.
+	Now func() time.Time
+}
+
+// Verify checks the token's signature, issuer, audience and validity window and
+// returns its claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a JWS compact serialization")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("token signature: %w", err)
+	}
+	key, err := v.Keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+	var raw struct {
+		Iss     string          `json:"iss"`
+		Sub     string          `json:"sub"`
+		Aud     json.RawMessage `json:"aud"`
+		Exp     *float64        `json:"exp"`
+		Nbf     *float64        `json:"nbf"`
+		Scope   string          `json:"scope"`
+		Scp     []string        `json:"scp"`
+		Patient string          `json:"patient"`
+	}
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, fmt.Errorf("token claims: %w", err)
+	}
+	c := &Claims{Subject: raw.Sub, Issuer: raw.Iss, Scopes: append(strings.Fields(raw.Scope), raw.Scp...), Patient: raw.Patient}
+	if len(raw.Aud) > 0 {
+		var one string
+		if json.Unmarshal(raw.Aud, &one) == nil {
+			c.Audience = []string{one}
+		} else if err := json.Unmarshal(raw.Aud, &c.Audience); err != nil {
+			return nil, fmt.Errorf("token aud: %w", err)
+		}
+	}
+	if c.Issuer != v.Issuer {
+		return nil, fmt.Errorf("token issuer %q is not trusted", c.Issuer)
+	}
+	if v.Audience != "" && !contains(c.Audience, v.Audience) {
+		return nil, fmt.Errorf("token audience does not include %q", v.Audience)
+	}
+	now := v.now()
+	if raw.Exp == nil {
+		return nil, errors.New("token has no exp")
+	}
+	c.ExpiresAt = time.Unix(int64(*raw.Exp), 0)
+	if now.After(c.ExpiresAt.Add(v.Leeway)) {
+		return nil, errors.New("token expired")
+	}
+	if raw.Nbf != nil && now.Add(v.Leeway).Before(time.Unix(int64(*raw.Nbf), 0)) {
+		return nil, errors.New("token not yet valid")
+	}
+	return c, nil
+}
+
+func (v *Verifier) now() time.Time {
+	if v.Now != nil {
+		return v.Now()
+	}
+	return time.Now()
+}
+
+// decodeSegment base64url-decodes a JWS segment into out.
+func decodeSegment(seg string, out any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+// verifySignature checks sig over signingInput for the JWS alg. Only asymmetric
+// algorithms are accepted, so a token can't pick "none" or an HMAC keyed by our
+// public key.
+func verifySignature(alg string, key crypto.PublicKey, signingInput string, sig []byte) error {
+	var h hash.Hash
+	var ch crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		h, ch = sha256.New(), crypto.SHA256
+	case "384":
+		h, ch = sha512.New384(), crypto.SHA384
+	case "512":
+		h, ch = sha512.New(), crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported token alg %q", alg)
+	}
+	h.Write([]byte(signingInput))
+	digest := h.Sum(nil)
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("alg %s needs an RSA key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, ch, digest, sig); err != nil {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	case strings.HasPrefix(alg, "ES"):
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig)%2 != 0 {
+			return fmt.Errorf("alg %s needs an EC key and an r||s signature", alg)
+		}
+		r := new(big.Int).SetBytes(sig[:len(sig)/2])
+		s := new(big.Int).SetBytes(sig[len(sig)/2:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported token alg %q", alg)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type claimsKey struct{}
+
+// WithClaims returns a context carrying the request's validated token claims.
+func WithClaims(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, c)
+}
+
+// FromContext returns the claims stored by WithClaims, or nil.
+func FromContext(ctx context.Context) *Claims {
+	c, _ := ctx.Value(claimsKey{}).(*Claims)
+	return c
+}
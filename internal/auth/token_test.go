@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testIssuer is a JWKS endpoint serving signing keys that can be rotated mid-test.
+type testIssuer struct {
+	srv     *httptest.Server
+	fetches atomic.Int32
+
+	mu   sync.Mutex
+	kid  string
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	jwks []map[string]string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	iss := &testIssuer{}
+	iss.rotate(t, "k1")
+	iss.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		iss.fetches.Add(1)
+		iss.mu.Lock()
+		defer iss.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": iss.jwks})
+	}))
+	t.Cleanup(iss.srv.Close)
+	return iss
+}
+
+// rotate replaces the issuer's keys with a fresh RSA key and P-256 key under kid
+// (RSA) and kid+"-ec" (EC).
+func (iss *testIssuer) rotate(t *testing.T, kid string) {
+	t.Helper()
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	iss.kid, iss.rsa, iss.ec = kid, rk, ek
+	iss.jwks = []map[string]string{
+		{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(rk.N), "e": b64(big.NewInt(int64(rk.E)))},
+		{"kty": "EC", "kid": kid + "-ec", "crv": "P-256", "x": b64(ek.X), "y": b64(ek.Y)},
+		{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+	}
+}
+
+func (iss *testIssuer) verifier() *Verifier {
+	return &Verifier{Issuer: "https://issuer.test", Keys: NewKeySet(iss.srv.URL, time.Second)}
+}
+
+// sign returns a compact JWS of claims signed with alg ("RS256" or "ES256") by the
+// current key.
+func (iss *testIssuer) sign(t *testing.T, alg string, claims map[string]any) string {
+	t.Helper()
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	kid := iss.kid
+	if alg == "ES256" {
+		kid += "-ec"
+	}
+	input := segment(t, map[string]any{"alg": alg, "kid": kid}) + "." + segment(t, claims)
+	digest := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch alg {
+	case "RS256":
+		s, err := rsa.SignPKCS1v15(rand.Reader, iss.rsa, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = s
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, iss.ec, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		t.Fatalf("unsupported alg %s", alg)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func segment(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss":     "https://issuer.test",
+		"sub":     "user-1",
+		"aud":     "https://fhir.test",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+		"scope":   "launch patient/Patient.read",
+		"patient": "123",
+	}
+}
+
+func TestVerifyValidToken(t *testing.T) {
+	iss := newTestIssuer(t)
+	for _, alg := range []string{"RS256", "ES256"} {
+		t.Run(alg, func(t *testing.T) {
+			v := iss.verifier()
+			v.Audience = "https://fhir.test"
+			c, err := v.Verify(context.Background(), iss.sign(t, alg, validClaims()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Subject != "user-1" || c.Patient != "123" {
+				t.Errorf("claims = %+v", c)
+			}
+			if want := []string{"launch", "patient/Patient.read"}; strings.Join(c.Scopes, " ") != strings.Join(want, " ") {
+				t.Errorf("Scopes = %q, want %q", c.Scopes, want)
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	iss := newTestIssuer(t)
+	with := func(k string, v any) map[string]any {
+		c := validClaims()
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+		return c
+	}
+	tests := []struct {
+		name  string
+		token func() string
+	}{
+		{"untrusted issuer", func() string { return iss.sign(t, "RS256", with("iss", "https://evil.test")) }},
+		{"wrong audience", func() string { return iss.sign(t, "RS256", with("aud", "https://other.test")) }},
+		{"expired", func() string { return iss.sign(t, "RS256", with("exp", time.Now().Add(-time.Hour).Unix())) }},
+		{"no exp", func() string { return iss.sign(t, "RS256", with("exp", nil)) }},
+		{"not yet valid", func() string { return iss.sign(t, "RS256", with("nbf", time.Now().Add(time.Hour).Unix())) }},
+		{"tampered claims", func() string {
+			parts := strings.Split(iss.sign(t, "RS256", validClaims()), ".")
+			return parts[0] + "." + segment(t, with("patient", "456")) + "." + parts[2]
+		}},
+		{"alg none", func() string {
+			return segment(t, map[string]any{"alg": "none", "kid": "k1"}) + "." + segment(t, validClaims()) + "."
+		}},
+		{"HMAC alg", func() string {
+			parts := strings.Split(iss.sign(t, "RS256", validClaims()), ".")
+			return segment(t, map[string]any{"alg": "HS256", "kid": "k1"}) + "." + parts[1] + "." + parts[2]
+		}},
+		{"not a JWS", func() string { return "abc.def" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := iss.verifier()
+			v.Audience = "https://fhir.test"
+			if c, err := v.Verify(context.Background(), tt.token()); err == nil {
+				t.Errorf("Verify accepted token: %+v", c)
+			}
+		})
+	}
+}
+
+func TestVerifyLeeway(t *testing.T) {
+	iss := newTestIssuer(t)
+	claims := validClaims()
+	claims["exp"] = time.Now().Add(-10 * time.Second).Unix()
+	token := iss.sign(t, "RS256", claims)
+	v := iss.verifier()
+	if _, err := v.Verify(context.Background(), token); err == nil {
+		t.Fatal("expired token accepted without leeway")
+	}
+	v.Leeway = 30 * time.Second
+	if _, err := v.Verify(context.Background(), token); err != nil {
+		t.Fatalf("token within leeway rejected: %v", err)
+	}
+}
+
+func TestClaimsContext(t *testing.T) {
+	if FromContext(context.Background()) != nil {
+		t.Fatal("claims on empty context")
+	}
+	c := &Claims{Subject: "user-1"}
+	if got := FromContext(WithClaims(context.Background(), c)); got != c {
+		t.Errorf("FromContext = %v, want %v", got, c)
+	}
+}
@@ -3,14 +3,19 @@ package beclient
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
 // Client abstracts the backend API used to fetch patient payloads.
 type Client interface {
 	GetPatient(ctx context.Context, id string, inHeaders http.Header) (status int, body []byte, headers http.Header, err error)
+	// Probe makes a cheap call to verify connectivity, TLS and auth to the backend.
+	Probe(ctx context.Context) error
 }
 
 // HTTPClient is a concrete Client using net/http.
@@ -18,10 +23,79 @@ type HTTPClient struct {
 	BaseURL  string
 	Timeout  time.Duration
 	Insecure bool // mirrors curl -k for dev
+	// DefaultHeaders are sent to the BE when the incoming request doesn't carry them
+	// (tenant routing X-* headers).
+	DefaultHeaders map[string]string
+	// UserAgent is sent when the incoming request has no User-Agent of its own.
+	UserAgent string
+	// CorrelationHeader carries the request's correlation id (see WithCorrelationID) to the BE.
+	CorrelationHeader string
+	// AcceptLanguage is sent when the incoming request has no Accept-Language. Empty
+	// sends none.
+	AcceptLanguage string
+	// Lang is the BE lang query parameter used when neither the incoming request nor
+	// AcceptLanguage names a language. Empty omits the parameter.
+	Lang string
+	// RequestInterceptors run, in order, on every outbound request once the standard
+	// headers are set (e.g. to add a per-request signed token or tenant routing).
+	RequestInterceptors []RequestInterceptor
+	// ResponseInterceptors run, in order, on every GetPatient response body.
+	ResponseInterceptors []ResponseInterceptor
+	// ForwardAuthorization copies the incoming Authorization header to the BE. Turn it
+	// off when the caller's token is meant for this server (SMART auth enabled).
+	ForwardAuthorization bool
+	// Authorization, when set, is the BE credential sent on every call. It takes the
+	// place of any forwarded Authorization header.
+	Authorization string
 }
 
-func NewHTTPClient(baseURL string, timeout time.Duration, insecure bool) *HTTPClient {
-	return &HTTPClient{BaseURL: baseURL, Timeout: timeout, Insecure: insecure}
+// RequestInterceptor adjusts an outbound BE request. An error aborts the call.
+type RequestInterceptor func(req *http.Request) error
+
+// ResponseInterceptor observes a BE response and may rewrite its body (e.g. to record
+// metrics or normalize error bodies). resp.Body is already consumed; use body.
+type ResponseInterceptor func(resp *http.Response, body []byte) ([]byte, error)
+
+// LangHeader is set on the headers GetPatient returns to the lang parameter it sent,
+// so callers know which language the record was requested in.
+const LangHeader = "X-Backend-Lang"
+
+// DefaultCorrelationHeader is the header the EMPI team correlates requests by.
+const DefaultCorrelationHeader = "X-Correlation-ID"
+
+type correlationKey struct{}
+
+// WithCorrelationID returns ctx carrying the correlation id forwarded to the BE.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation id stored on ctx, if any.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// DefaultUserAgent mimics the browser the BE was originally exercised with.
+const DefaultUserAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Safari/537.36"
+
+// DefaultTenantHeaders returns the X-* header defaults the BE requires.
+func DefaultTenantHeaders() map[string]string {
+	return map[string]string{
+		"X-Group":    "58",
+		"X-Hospital": "59",
+		"X-Location": "59",
+		"X-Module":   "empi",
+		"X-User":     "8008",
+	}
+}
+
+// NewHTTPClient builds an HTTPClient. A nil defaultHeaders uses DefaultTenantHeaders.
+func NewHTTPClient(baseURL string, timeout time.Duration, insecure bool, defaultHeaders map[string]string) *HTTPClient {
+	if defaultHeaders == nil {
+		defaultHeaders = DefaultTenantHeaders()
+	}
+	return &HTTPClient{BaseURL: baseURL, Timeout: timeout, Insecure: insecure, DefaultHeaders: defaultHeaders, UserAgent: DefaultUserAgent, CorrelationHeader: DefaultCorrelationHeader, ForwardAuthorization: true}
 }
 
 func (c *HTTPClient) httpClient() *http.Client {
@@ -34,44 +108,138 @@ func (c *HTTPClient) httpClient() *http.Client {
 
 func (c *HTTPClient) GetPatient(ctx context.Context, id string, inHeaders http.Header) (int, []byte, http.Header, error) {
 	urlStr := c.BaseURL + "/" + id + "?includeClosed=true"
+	lang := backendLang(firstNonEmpty(inHeaders.Get("Accept-Language"), c.AcceptLanguage), c.Lang)
+	if lang != "" {
+		urlStr += "&lang=" + url.QueryEscape(lang)
+	}
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
 	if err != nil {
 		return 0, nil, nil, err
 	}
-	// Important headers from incoming request, with defaults if missing
-	setOrDefault := func(name, def string) {
-		if v := inHeaders.Get(name); v != "" {
-			req.Header.Set(name, v)
-		} else if def != "" {
-			req.Header.Set(name, def)
-		}
-	}
-	setOrDefault("Accept", "application/json, text/plain, */*")
-	if v := inHeaders.Get("Accept-Language"); v != "" {
-		req.Header.Set("Accept-Language", v)
+	c.applyHeaders(req, inHeaders)
+	if rid := CorrelationID(ctx); rid != "" && c.CorrelationHeader != "" {
+		req.Header.Set(c.CorrelationHeader, rid)
 	}
-	if v := inHeaders.Get("Authorization"); v != "" {
-		req.Header.Set("Authorization", v)
-	}
-	if v := inHeaders.Get("Referer"); v != "" {
-		req.Header.Set("Referer", v)
+	if err := c.intercept(req); err != nil {
+		return 0, nil, nil, err
 	}
-	setOrDefault("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Safari/537.36")
-	// Required X-* headers for BE
-	setOrDefault("X-Group", "58")
-	setOrDefault("X-Hospital", "59")
-	setOrDefault("X-Location", "59")
-	setOrDefault("X-Module", "empi")
-	setOrDefault("X-User", "8008")
 
 	resp, err := c.httpClient().Do(req)
 	if err != nil {
 		return 0, nil, nil, err
 	}
 	defer resp.Body.Close()
+	headers := resp.Header.Clone()
+	if lang != "" {
+		headers.Set(LangHeader, lang)
+	}
 	b, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
 	if err != nil {
-		return resp.StatusCode, nil, resp.Header.Clone(), err
+		return resp.StatusCode, nil, headers, err
+	}
+	for _, ic := range c.ResponseInterceptors {
+		if b, err = ic(resp, b); err != nil {
+			return resp.StatusCode, nil, headers, err
+		}
+	}
+	return resp.StatusCode, b, headers, nil
+}
+
+// Probe issues a GET against BaseURL with the default headers. Any answer proves
+// connectivity and TLS; 401/403 and 5xx are reported as errors.
+func (c *HTTPClient) Probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL, nil)
+	if err != nil {
+		return err
+	}
+	c.applyHeaders(req, http.Header{})
+	if err := c.intercept(req); err != nil {
+		return err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("backend rejected credentials: status %d", resp.StatusCode)
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("backend unhealthy: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// intercept applies the RequestInterceptors to req.
+func (c *HTTPClient) intercept(req *http.Request) error {
+	for _, ic := range c.RequestInterceptors {
+		if err := ic(req); err != nil {
+			return fmt.Errorf("request interceptor: %w", err)
+		}
+	}
+	return nil
+}
+
+// forwardedHeaders are copied from the incoming request only when present.
+var forwardedHeaders = []string{"Accept-Language", "Authorization", "Referer"}
+
+// headerDefaults returns the headers sent when the incoming request doesn't carry them.
+func (c *HTTPClient) headerDefaults() map[string]string {
+	defaults := map[string]string{
+		"Accept":     "application/json, text/plain, */*",
+		"User-Agent": c.UserAgent,
+	}
+	if c.AcceptLanguage != "" {
+		defaults["Accept-Language"] = c.AcceptLanguage
+	}
+	// Required X-* headers for BE
+	for name, v := range c.DefaultHeaders {
+		defaults[name] = v
+	}
+	return defaults
+}
+
+// backendLang derives the BE lang parameter from an Accept-Language value: the
+// primary subtag of its first language range ("ar-SA;q=0.9, en" -> "ar"), else def.
+func backendLang(acceptLanguage, def string) string {
+	first, _, _ := strings.Cut(acceptLanguage, ",")
+	tag, _, _ := strings.Cut(first, ";")
+	primary, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+	if primary == "" || primary == "*" {
+		return def
+	}
+	return strings.ToLower(primary)
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// applyHeaders forwards the allowlisted incoming headers onto req and fills in
+// defaults for anything the caller didn't send. All BE calls share it.
+func (c *HTTPClient) applyHeaders(req *http.Request, inHeaders http.Header) {
+	for _, name := range forwardedHeaders {
+		if name == "Authorization" && !c.ForwardAuthorization {
+			continue
+		}
+		if v := inHeaders.Get(name); v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+	for name, def := range c.headerDefaults() {
+		if v := inHeaders.Get(name); v != "" {
+			req.Header.Set(name, v)
+		} else if def != "" {
+			req.Header.Set(name, def)
+		}
+	}
+	if c.Authorization != "" {
+		req.Header.Set("Authorization", c.Authorization)
 	}
-	return resp.StatusCode, b, resp.Header.Clone(), nil
 }
@@ -0,0 +1,201 @@
+package beclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// capture starts a BE stub that records the headers of the last request it saw.
+func capture(t *testing.T) (*httptest.Server, *http.Header) {
+	t.Helper()
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":{}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &got
+}
+
+func TestGetPatientAuthorization(t *testing.T) {
+	in := http.Header{"Authorization": {"Bearer smart-token"}}
+	tests := []struct {
+		name    string
+		forward bool
+		backend string
+		want    string
+	}{
+		{name: "forwarded by default", forward: true, want: "Bearer smart-token"},
+		{name: "not forwarded", forward: false, want: ""},
+		{name: "backend credential", forward: false, backend: "Basic YmU6c2VjcmV0", want: "Basic YmU6c2VjcmV0"},
+		{name: "backend credential wins", forward: true, backend: "Basic YmU6c2VjcmV0", want: "Basic YmU6c2VjcmV0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, got := capture(t)
+			c := NewHTTPClient(srv.URL, time.Second, false, nil)
+			c.ForwardAuthorization = tt.forward
+			c.Authorization = tt.backend
+			if _, _, _, err := c.GetPatient(context.Background(), "1", in); err != nil {
+				t.Fatal(err)
+			}
+			if v := got.Get("Authorization"); v != tt.want {
+				t.Errorf("Authorization = %q, want %q", v, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeSendsBackendAuthorization(t *testing.T) {
+	srv, got := capture(t)
+	c := NewHTTPClient(srv.URL, time.Second, false, nil)
+	c.ForwardAuthorization = false
+	c.Authorization = "Bearer be-token"
+	if err := c.Probe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Get("Authorization"); v != "Bearer be-token" {
+		t.Errorf("Authorization = %q, want %q", v, "Bearer be-token")
+	}
+}
+
+func TestApplyHeaders(t *testing.T) {
+	c := NewHTTPClient("http://be.test", time.Second, false, map[string]string{"X-Hospital": "59", "X-User": "8008"})
+	c.AcceptLanguage = "en"
+	in := http.Header{
+		"Accept-Language": {"ar"},
+		"Referer":         {"https://app.test/"},
+		"X-User":          {"42"},
+		"Cookie":          {"session=secret"},
+		"Origin":          {"https://app.test"},
+	}
+	req, _ := http.NewRequest(http.MethodGet, c.BaseURL, nil)
+	c.applyHeaders(req, in)
+	want := map[string]string{
+		"Accept-Language": "ar",                                // forwarded over the default
+		"Referer":         "https://app.test/",                 // forwarded
+		"X-User":          "42",                                // incoming value wins
+		"X-Hospital":      "59",                                // defaulted
+		"User-Agent":      DefaultUserAgent,                    // defaulted
+		"Accept":          "application/json, text/plain, */*", // defaulted
+		"Cookie":          "",                                  // not allowlisted
+		"Origin":          "",                                  // not allowlisted
+	}
+	for name, v := range want {
+		if got := req.Header.Get(name); got != v {
+			t.Errorf("%s = %q, want %q", name, got, v)
+		}
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, c.BaseURL, nil)
+	c.applyHeaders(req, http.Header{})
+	if got := req.Header.Get("Accept-Language"); got != "en" {
+		t.Errorf("default Accept-Language = %q, want en", got)
+	}
+	if got := req.Header.Get("Referer"); got != "" {
+		t.Errorf("Referer = %q, want none", got)
+	}
+}
+
+func TestGetPatientForwardsHeaders(t *testing.T) {
+	srv, got := capture(t)
+	c := NewHTTPClient(srv.URL, time.Second, false, nil)
+	ctx := WithCorrelationID(context.Background(), "rid-1")
+	if _, _, _, err := c.GetPatient(ctx, "1", http.Header{"User-Agent": {"app/1.0"}}); err != nil {
+		t.Fatal(err)
+	}
+	for name, v := range map[string]string{"User-Agent": "app/1.0", "X-Module": "empi", DefaultCorrelationHeader: "rid-1"} {
+		if g := got.Get(name); g != v {
+			t.Errorf("%s = %q, want %q", name, g, v)
+		}
+	}
+}
+
+func TestRequestInterceptors(t *testing.T) {
+	srv, got := capture(t)
+	c := NewHTTPClient(srv.URL, time.Second, false, nil)
+	var order []string
+	c.RequestInterceptors = []RequestInterceptor{
+		func(req *http.Request) error {
+			order = append(order, "first")
+			req.Header.Set("X-Signed-Token", "sig-1")
+			return nil
+		},
+		func(req *http.Request) error {
+			order = append(order, "second")
+			// Interceptors run after the standard headers, so they can override them.
+			req.Header.Set("X-Module", "custom")
+			return nil
+		},
+	}
+	if _, _, _, err := c.GetPatient(context.Background(), "1", http.Header{}); err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Get("X-Signed-Token"); v != "sig-1" {
+		t.Errorf("X-Signed-Token = %q, want sig-1", v)
+	}
+	if v := got.Get("X-Module"); v != "custom" {
+		t.Errorf("X-Module = %q, want custom", v)
+	}
+	if len(order) != 2 || order[0] != "first" {
+		t.Errorf("interceptor order = %v", order)
+	}
+
+	*got = nil
+	if err := c.Probe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Get("X-Signed-Token"); v != "sig-1" {
+		t.Errorf("Probe X-Signed-Token = %q, want sig-1", v)
+	}
+}
+
+func TestRequestInterceptorErrorAborts(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls++ }))
+	defer srv.Close()
+	c := NewHTTPClient(srv.URL, time.Second, false, nil)
+	c.RequestInterceptors = []RequestInterceptor{func(*http.Request) error { return errors.New("no token") }}
+	if _, _, _, err := c.GetPatient(context.Background(), "1", http.Header{}); err == nil || !strings.Contains(err.Error(), "no token") {
+		t.Errorf("err = %v, want the interceptor error", err)
+	}
+	if calls != 0 {
+		t.Errorf("BE called %d times after the interceptor failed", calls)
+	}
+}
+
+func TestResponseInterceptors(t *testing.T) {
+	srv, _ := capture(t)
+	c := NewHTTPClient(srv.URL, time.Second, false, nil)
+	var seenStatus int
+	c.ResponseInterceptors = []ResponseInterceptor{
+		func(resp *http.Response, body []byte) ([]byte, error) {
+			seenStatus = resp.StatusCode
+			return bytes.Replace(body, []byte(`{}`), []byte(`{"upi":"1"}`), 1), nil
+		},
+	}
+	_, body, _, err := c.GetPatient(context.Background(), "1", http.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"data":{"upi":"1"}}` {
+		t.Errorf("body = %s, want the rewritten body", body)
+	}
+	if seenStatus != http.StatusOK {
+		t.Errorf("interceptor saw status %d", seenStatus)
+	}
+
+	c.ResponseInterceptors = append(c.ResponseInterceptors, func(*http.Response, []byte) ([]byte, error) {
+		return nil, errors.New("rejected")
+	})
+	if _, _, _, err := c.GetPatient(context.Background(), "1", http.Header{}); err == nil {
+		t.Error("response interceptor error ignored")
+	}
+}
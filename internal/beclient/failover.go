@@ -0,0 +1,101 @@
+package beclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ServedByHeader is set on the headers returned by FailoverClient to name the
+// endpoint that produced the response.
+const ServedByHeader = "X-Backend-Endpoint"
+
+// FailoverTarget is one backend endpoint in a FailoverClient.
+type FailoverTarget struct {
+	Name   string
+	Client Client
+}
+
+// FailoverClient tries its targets in order, moving to the next one when a target
+// fails to connect, times out or answers 5xx. Any other answer, including 4xx such
+// as 404, is returned as-is. When ctx has a deadline, each attempt gets an equal share
+// of the time left among the remaining targets.
+type FailoverClient struct {
+	Targets []FailoverTarget
+}
+
+// NewFailoverClient builds a FailoverClient over the ordered base URLs (primary
+// first), creating each endpoint's client with newClient.
+func NewFailoverClient(baseURLs []string, newClient func(baseURL string) *HTTPClient) *FailoverClient {
+	fc := &FailoverClient{}
+	for _, u := range baseURLs {
+		fc.Targets = append(fc.Targets, FailoverTarget{Name: u, Client: newClient(u)})
+	}
+	return fc
+}
+
+func (f *FailoverClient) GetPatient(ctx context.Context, id string, inHeaders http.Header) (int, []byte, http.Header, error) {
+	if len(f.Targets) == 0 {
+		return 0, nil, nil, errors.New("failover client has no targets")
+	}
+	var (
+		status  int
+		body    []byte
+		headers http.Header
+		err     error
+		tried   string
+	)
+	for i, t := range f.Targets {
+		tried = t.Name
+		attemptCtx, cancel := attemptContext(ctx, len(f.Targets)-i)
+		status, body, headers, err = t.Client.GetPatient(attemptCtx, id, inHeaders)
+		cancel()
+		if err == nil && status < 500 {
+			if headers == nil {
+				headers = http.Header{}
+			}
+			headers.Set(ServedByHeader, t.Name)
+			return status, body, headers, nil
+		}
+		if ctx.Err() != nil {
+			// The caller gave up; another endpoint won't help.
+			break
+		}
+		if i < len(f.Targets)-1 {
+			log.Printf("Backend %s failed (status=%d err=%v), failing over to %s", t.Name, status, err, f.Targets[i+1].Name)
+		}
+	}
+	if headers != nil {
+		headers.Set(ServedByHeader, tried)
+	}
+	return status, body, headers, err
+}
+
+// attemptContext bounds one attempt to an equal share of the time left before ctx's
+// deadline among the remaining targets, so a slow target leaves time to fail over.
+func attemptContext(ctx context.Context, remaining int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || remaining <= 1 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(remaining))
+}
+
+// Probe succeeds when any target is reachable, reporting every failure otherwise.
+func (f *FailoverClient) Probe(ctx context.Context) error {
+	var errs []error
+	for _, t := range f.Targets {
+		err := t.Client.Probe(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", t.Name, err))
+	}
+	if len(errs) == 0 {
+		return errors.New("failover client has no targets")
+	}
+	return errors.Join(errs...)
+}
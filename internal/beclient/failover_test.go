@@ -0,0 +1,58 @@
+package beclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFailoverOnSlowPrimary(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast, _ := capture(t)
+
+	// The per-endpoint client timeout outlasts the request deadline, as in main.go.
+	fc := NewFailoverClient([]string{slow.URL, fast.URL}, func(u string) *HTTPClient {
+		return NewHTTPClient(u, 15*time.Second, false, nil)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	status, _, headers, err := fc.GetPatient(ctx, "1", http.Header{})
+	if err != nil {
+		t.Fatalf("no failover before the deadline: %v", err)
+	}
+	if status != http.StatusOK || headers.Get(ServedByHeader) != fast.URL {
+		t.Errorf("status = %d served by %q, want 200 from the secondary", status, headers.Get(ServedByHeader))
+	}
+}
+
+func TestAttemptContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	requestDeadline, _ := ctx.Deadline()
+
+	first, done := attemptContext(ctx, 2)
+	defer done()
+	if d, _ := first.Deadline(); time.Until(d) > 600*time.Millisecond {
+		t.Errorf("first of two attempts gets %s, want about half the time left", time.Until(d))
+	}
+	last, done := attemptContext(ctx, 1)
+	defer done()
+	if d, _ := last.Deadline(); !d.Equal(requestDeadline) {
+		t.Errorf("last attempt deadline = %v, want the request deadline %v", d, requestDeadline)
+	}
+	unbounded, done := attemptContext(context.Background(), 2)
+	defer done()
+	if _, ok := unbounded.Deadline(); ok {
+		t.Error("attempt without a request deadline got one")
+	}
+}
@@ -0,0 +1,54 @@
+package fhir
+
+// Coding is a terminology code used by the transform's mapping tables.
+type Coding struct {
+	System  string
+	Code    string
+	Display string
+}
+
+const (
+	v2ContactRoleSystem = "http://terminology.hl7.org/CodeSystem/v2-0131"
+	v3RoleCodeSystem    = "http://terminology.hl7.org/CodeSystem/v3-RoleCode"
+)
+
+// DefaultContactRelationships returns the default table mapping lower-cased backend
+// contact relationships onto Patient.contact.relationship codings: contact roles from
+// v2-0131, and personal relationships (which v2-0131 has no codes for) from
+// v3-RoleCode.
+func DefaultContactRelationships() map[string]Coding {
+	emergency := Coding{v2ContactRoleSystem, "C", "Emergency Contact"}
+	nextOfKin := Coding{v2ContactRoleSystem, "N", "Next-of-Kin"}
+	role := func(code, display string) Coding { return Coding{v3RoleCodeSystem, code, display} }
+	return map[string]Coding{
+		"emergency":         emergency,
+		"emergency contact": emergency,
+		"next of kin":       nextOfKin,
+		"next-of-kin":       nextOfKin,
+		"nok":               nextOfKin,
+		"employer":          {v2ContactRoleSystem, "E", "Employer"},
+		"guardian":          role("GUARD", "guardian"),
+		"spouse":            role("SPS", "spouse"),
+		"husband":           role("HUSB", "husband"),
+		"wife":              role("WIFE", "wife"),
+		"partner":           role("DOMPART", "domestic partner"),
+		"parent":            role("PRN", "parent"),
+		"father":            role("FTH", "father"),
+		"mother":            role("MTH", "mother"),
+		"child":             role("CHILD", "child"),
+		"son":               role("SON", "natural son"),
+		"daughter":          role("DAU", "natural daughter"),
+		"sibling":           role("SIB", "sibling"),
+		"brother":           role("BRO", "brother"),
+		"sister":            role("SIS", "sister"),
+		"grandparent":       role("GRPRN", "grandparent"),
+		"grandfather":       role("GRFTH", "grandfather"),
+		"grandmother":       role("GRMTH", "grandmother"),
+		"uncle":             role("UNCLE", "uncle"),
+		"aunt":              role("AUNT", "aunt"),
+		"cousin":            role("COUSN", "cousin"),
+		"friend":            role("FRND", "unrelated friend"),
+		"neighbor":          role("NBOR", "neighbor"),
+		"neighbour":         role("NBOR", "neighbor"),
+	}
+}
@@ -0,0 +1,120 @@
+package fhir
+
+import "strings"
+
+// originalCountryExtensionURL keeps the backend country value when it couldn't be
+// mapped onto an ISO 3166 alpha-2 code.
+const originalCountryExtensionURL = "urn:empi:extension:original-country"
+
+const (
+	nationalityExtensionURL = "http://hl7.org/fhir/StructureDefinition/patient-nationality"
+	iso3166System           = "urn:iso:std:iso:3166"
+)
+
+// DefaultCountryCodes returns the default table mapping country names and ISO 3166
+// alpha-3 codes (upper-cased) onto alpha-2 codes.
+func DefaultCountryCodes() map[string]string {
+	return map[string]string{
+		"SAU": "SA", "SAUDI ARABIA": "SA", "KINGDOM OF SAUDI ARABIA": "SA", "KSA": "SA",
+		"ARE": "AE", "UNITED ARAB EMIRATES": "AE", "UAE": "AE",
+		"BHR": "BH", "BAHRAIN": "BH",
+		"KWT": "KW", "KUWAIT": "KW",
+		"OMN": "OM", "OMAN": "OM",
+		"QAT": "QA", "QATAR": "QA",
+		"YEM": "YE", "YEMEN": "YE",
+		"JOR": "JO", "JORDAN": "JO",
+		"EGY": "EG", "EGYPT": "EG",
+		"SYR": "SY", "SYRIA": "SY",
+		"LBN": "LB", "LEBANON": "LB",
+		"IRQ": "IQ", "IRAQ": "IQ",
+		"SDN": "SD", "SUDAN": "SD",
+		"PAK": "PK", "PAKISTAN": "PK",
+		"IND": "IN", "INDIA": "IN",
+		"BGD": "BD", "BANGLADESH": "BD",
+		"LKA": "LK", "SRI LANKA": "LK",
+		"PHL": "PH", "PHILIPPINES": "PH",
+		"IDN": "ID", "INDONESIA": "ID",
+		"NPL": "NP", "NEPAL": "NP",
+		"GBR": "GB", "UNITED KINGDOM": "GB", "UK": "GB",
+		"USA": "US", "UNITED STATES": "US", "UNITED STATES OF AMERICA": "US",
+		"CAN": "CA", "CANADA": "CA",
+		"FRA": "FR", "FRANCE": "FR",
+		"DEU": "DE", "GERMANY": "DE",
+	}
+}
+
+// iso3166Alpha2Codes are the officially assigned ISO 3166-1 alpha-2 codes.
+const iso3166Alpha2Codes = `
+AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ
+BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ
+CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ
+DE DJ DK DM DO DZ
+EC EE EG EH ER ES ET
+FI FJ FK FM FO FR
+GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY
+HK HM HN HR HT HU
+ID IE IL IM IN IO IQ IR IS IT
+JE JM JO JP
+KE KG KH KI KM KN KP KR KW KY KZ
+LA LB LC LI LK LR LS LT LU LV LY
+MA MC MD ME MF MG MH MK ML MM MN MO MP MQ MR MS MT MU MV MW MX MY MZ
+NA NC NE NF NG NI NL NO NP NR NU NZ
+OM
+PA PE PF PG PH PK PL PM PN PR PS PT PW PY
+QA
+RE RO RS RU RW
+SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ
+TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ
+UA UG UM US UY UZ
+VA VC VE VG VI VN VU
+WF WS
+YE YT
+ZA ZM ZW
+`
+
+// iso3166Alpha2 is the set of iso3166Alpha2Codes.
+var iso3166Alpha2 = func() map[string]bool {
+	codes := map[string]bool{}
+	for _, c := range strings.Fields(iso3166Alpha2Codes) {
+		codes[c] = true
+	}
+	return codes
+}()
+
+// normalizeCountry maps raw onto an ISO 3166 alpha-2 code using table. Values that
+// already are an assigned alpha-2 code pass through upper-cased, so the table only
+// needs names and alpha-3 codes. ok is false when raw couldn't be mapped; code is then the
+// upper-cased raw value.
+func normalizeCountry(raw string, table map[string]string) (code string, ok bool) {
+	key := strings.ToUpper(strings.Join(strings.Fields(raw), " "))
+	if c, found := table[key]; found {
+		return c, true
+	}
+	if iso3166Alpha2[key] {
+		return key, true
+	}
+	return strings.ToUpper(raw), false
+}
+
+// nationalityExtension builds the patient-nationality extension from the backend
+// nationalityCode/nationality, coded as ISO 3166 alpha-2 when the country table maps
+// it and keeping the raw value as display (or as text alone when unmapped).
+func nationalityExtension(payload map[string]any, opts TransformOptions) (map[string]any, bool) {
+	pv := opts.placeholders()
+	raw := pv.str(payload, "nationalityCode", "nationality")
+	if raw == "" {
+		return nil, false
+	}
+	cc := map[string]any{"text": raw}
+	if code, ok := normalizeCountry(raw, opts.CountryCodes); ok {
+		display := raw
+		if text := pv.str(payload, "nationality"); text != "" {
+			display = text
+		}
+		cc = map[string]any{"coding": []any{map[string]any{"system": iso3166System, "code": code, "display": display}}}
+	}
+	return map[string]any{
+		"url":       nationalityExtensionURL,
+		"extension": []any{map[string]any{"url": "code", "valueCodeableConcept": cc}},
+	}, true
+}
@@ -0,0 +1,103 @@
+package fhir
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeCountry(t *testing.T) {
+	tests := []struct {
+		raw  string
+		code string
+		ok   bool
+	}{
+		{"SA", "SA", true},
+		{"ma", "MA", true}, // alpha-2 outside the default table
+		{" uk ", "GB", true},
+		{"Saudi  Arabia", "SA", true},
+		{"SAU", "SA", true},
+		{"Morocco", "MOROCCO", false},
+		{"MAR", "MAR", false},
+		{"M1", "M1", false},
+		{"XX", "XX", false}, // two letters but not assigned
+		{"zz", "ZZ", false},
+	}
+	for _, tt := range tests {
+		code, ok := normalizeCountry(tt.raw, DefaultCountryCodes())
+		if code != tt.code || ok != tt.ok {
+			t.Errorf("normalizeCountry(%q) = %q, %v; want %q, %v", tt.raw, code, ok, tt.code, tt.ok)
+		}
+	}
+}
+
+func TestTransformAddressCountry(t *testing.T) {
+	p := transform(t, `{"data":{"upi":"123","city":"Rabat","country":"MA"}}`, DefaultTransformOptions())
+	if got := compact(t, p["address"]); got != `[{"city":"Rabat","country":"MA"}]` {
+		t.Errorf("address = %s, want MA without the original-country extension", got)
+	}
+	p = transform(t, `{"data":{"upi":"123","city":"Nowhere","country":"XX"}}`, DefaultTransformOptions())
+	if got := compact(t, p["address"]); !strings.Contains(got, originalCountryExtensionURL) || !strings.Contains(got, `"XX"`) {
+		t.Errorf("address = %s, want XX kept in the original-country extension", got)
+	}
+}
+
+func TestISO3166Alpha2CoversCountryTable(t *testing.T) {
+	if n := len(iso3166Alpha2); n != 249 {
+		t.Errorf("%d alpha-2 codes, want 249", n)
+	}
+	for key, code := range DefaultCountryCodes() {
+		if !iso3166Alpha2[code] {
+			t.Errorf("DefaultCountryCodes()[%q] = %q is not an assigned alpha-2 code", key, code)
+		}
+	}
+}
+
+func TestTransformNationality(t *testing.T) {
+	tests := []struct {
+		name string
+		be   string
+		want string
+	}{
+		{"mapped", `{"data":{"upi":"123","nationalityCode":"SAU","nationality":"Saudi Arabia"}}`,
+			`{"coding":[{"code":"SA","display":"Saudi Arabia","system":"urn:iso:std:iso:3166"}]}`},
+		{"unmapped", `{"data":{"upi":"123","nationality":"Narnia"}}`, `{"text":"Narnia"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := TransformBackendToFHIRPatient([]byte(tt.be), "123")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ValidatePatientR4(out); err != nil {
+				t.Fatalf("invalid Patient: %v", err)
+			}
+			var p struct {
+				Extension []struct {
+					URL       string
+					Extension []struct {
+						URL                  string
+						ValueCodeableConcept json.RawMessage
+					}
+				}
+			}
+			if err := json.Unmarshal(out, &p); err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			for _, ext := range p.Extension {
+				if ext.URL != nationalityExtensionURL {
+					continue
+				}
+				for _, sub := range ext.Extension {
+					if sub.URL == "code" {
+						got = string(sub.ValueCodeableConcept)
+					}
+				}
+			}
+			if got != tt.want {
+				t.Errorf("nationality code = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
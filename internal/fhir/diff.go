@@ -0,0 +1,79 @@
+package fhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// diffIgnored are top-level Patient elements that never count as a change.
+var diffIgnored = map[string]bool{"id": true, "meta": true}
+
+// DiffPatients compares two Patient JSON documents and returns the sorted element
+// paths that differ (e.g. "gender", "name[0].given[1]"), ignoring meta and id.
+// An empty result means the resources are equivalent.
+func DiffPatients(a, b []byte) ([]string, error) {
+	var am, bm map[string]any
+	if err := json.Unmarshal(a, &am); err != nil {
+		return nil, fmt.Errorf("first Patient: %w", err)
+	}
+	if err := json.Unmarshal(b, &bm); err != nil {
+		return nil, fmt.Errorf("second Patient: %w", err)
+	}
+	for k := range diffIgnored {
+		delete(am, k)
+		delete(bm, k)
+	}
+	var paths []string
+	diffValues("", am, bm, &paths)
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// diffValues appends to paths every location under path where a and b differ.
+func diffValues(path string, a, b any, paths *[]string) {
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool, len(av)+len(bv))
+		for k := range av {
+			keys[k] = true
+		}
+		for k := range bv {
+			keys[k] = true
+		}
+		for k := range keys {
+			child := k
+			if path != "" {
+				child = path + "." + k
+			}
+			diffValues(child, av[k], bv[k], paths)
+		}
+		return
+	case []any:
+		bv, ok := b.([]any)
+		if !ok {
+			break
+		}
+		n := max(len(av), len(bv))
+		for i := 0; i < n; i++ {
+			var ai, bi any
+			if i < len(av) {
+				ai = av[i]
+			}
+			if i < len(bv) {
+				bi = bv[i]
+			}
+			diffValues(path+"["+strconv.Itoa(i)+"]", ai, bi, paths)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*paths = append(*paths, path)
+	}
+}
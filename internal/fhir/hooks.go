@@ -0,0 +1,21 @@
+package fhir
+
+// PostTransformHook customizes a Patient after the default mapping and before
+// normalization. patient is the Patient being built (a JSON object) and source the
+// unwrapped backend record; hooks may add or override any field, but the result must
+// still be a valid R4 Patient. A returned error fails the transform.
+type PostTransformHook func(patient map[string]any, source map[string]any) error
+
+// StringExtensionHook returns a hook copying the backend field sourceKey, when
+// present, into a Patient extension with the given url and a valueString. Values in
+// placeholderValues (pass TransformOptions.Placeholders; nil means the defaults) count
+// as absent. It serves as an example of a site-specific hook.
+func StringExtensionHook(url, sourceKey string, placeholderValues []string) PostTransformHook {
+	pv := TransformOptions{Placeholders: placeholderValues}.placeholders()
+	return func(patient, source map[string]any) error {
+		if v := pv.str(source, sourceKey); v != "" {
+			addExtension(patient, map[string]any{"url": url, "valueString": v})
+		}
+		return nil
+	}
+}
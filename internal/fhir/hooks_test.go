@@ -0,0 +1,71 @@
+package fhir
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStringExtensionHook(t *testing.T) {
+	const url = "urn:site:extension:tribe"
+	opts := DefaultTransformOptions()
+	opts.Hooks = []PostTransformHook{StringExtensionHook(url, "tribe", nil)}
+	out, err := TransformBackendToFHIRPatientWithOptions([]byte(`{"data":{"upi":"123","religion":"Islam","tribe":"Qahtan"}}`), "123", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidatePatientR4(out); err != nil {
+		t.Fatalf("hooked Patient invalid: %v", err)
+	}
+	if !strings.Contains(string(out), `{"url":"`+url+`","valueString":"Qahtan"}`) {
+		t.Errorf("custom extension missing: %s", out)
+	}
+	if !strings.Contains(string(out), religionExtensionURL) {
+		t.Errorf("hook replaced the mapped extensions: %s", out)
+	}
+
+	// An absent or placeholder source field adds nothing.
+	out, err = TransformBackendToFHIRPatientWithOptions([]byte(`{"data":{"upi":"123","tribe":"-"}}`), "123", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), url) {
+		t.Errorf("extension added for a placeholder: %s", out)
+	}
+
+	// Configured placeholders apply to the hook too.
+	opts.Placeholders = []string{"unknown"}
+	opts.Hooks = []PostTransformHook{StringExtensionHook(url, "tribe", opts.Placeholders)}
+	out, err = TransformBackendToFHIRPatientWithOptions([]byte(`{"data":{"upi":"123","tribe":"Unknown"}}`), "123", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), url) {
+		t.Errorf("extension added for a configured placeholder: %s", out)
+	}
+}
+
+func TestPostTransformHookErrors(t *testing.T) {
+	be := []byte(`{"data":{"upi":"123"}}`)
+	tests := []struct {
+		name string
+		hook PostTransformHook
+		want string
+	}{
+		{"hook error", func(patient, source map[string]any) error { return errors.New("boom") }, "post-transform hook 0: boom"},
+		{"invalid result", func(patient, source map[string]any) error {
+			patient["gender"] = "m"
+			return nil
+		}, "normalization failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultTransformOptions()
+			opts.Hooks = []PostTransformHook{tt.hook}
+			_, err := TransformBackendToFHIRPatientWithOptions(be, "123", opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
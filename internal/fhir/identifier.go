@@ -0,0 +1,72 @@
+package fhir
+
+import (
+	"regexp"
+	"strings"
+)
+
+// v2IdentifierTypeSystem is the Identifier.type code system (v2-0203).
+const v2IdentifierTypeSystem = "http://terminology.hl7.org/CodeSystem/v2-0203"
+
+// DefaultIdentifierTypes returns the default table mapping lower-cased backend idType
+// values onto v2-0203 identifier types.
+func DefaultIdentifierTypes() map[string]Coding {
+	ni := Coding{v2IdentifierTypeSystem, "NI", "National unique individual identifier"}
+	return map[string]Coding{
+		"national":        ni,
+		"national id":     ni,
+		"nationalid":      ni,
+		"nid":             ni,
+		"passport":        {v2IdentifierTypeSystem, "PPN", "Passport number"},
+		"iqama":           {v2IdentifierTypeSystem, "PRC", "Permanent Resident Card Number"},
+		"residency":       {v2IdentifierTypeSystem, "PRC", "Permanent Resident Card Number"},
+		"driver license":  {v2IdentifierTypeSystem, "DL", "Driver's license number"},
+		"drivers license": {v2IdentifierTypeSystem, "DL", "Driver's license number"},
+		"driving license": {v2IdentifierTypeSystem, "DL", "Driver's license number"},
+	}
+}
+
+// mrnIdentifierType is the Identifier.type of the backend medical record number.
+var mrnIdentifierType = Coding{v2IdentifierTypeSystem, "MR", "Medical record number"}
+
+// identifierType builds the Identifier.type CodeableConcept for a backend idType,
+// coded when types maps it and falling back to the raw value as text.
+func identifierType(idType string, types map[string]Coding) map[string]any {
+	c, ok := types[strings.ToLower(strings.TrimSpace(idType))]
+	if !ok {
+		return map[string]any{"text": idType}
+	}
+	return codeableConcept(c)
+}
+
+// codeableConcept wraps a single Coding.
+func codeableConcept(c Coding) map[string]any {
+	return map[string]any{"coding": []any{map[string]any{"system": c.System, "code": c.Code, "display": c.Display}}}
+}
+
+// codedText builds a CodeableConcept for a backend value, coded when table maps its
+// lower-cased form and always keeping the raw text.
+func codedText(raw string, table map[string]Coding) map[string]any {
+	cc := map[string]any{}
+	if c, ok := table[strings.ToLower(strings.TrimSpace(raw))]; ok {
+		cc = codeableConcept(c)
+	}
+	cc["text"] = raw
+	return cc
+}
+
+// oidRe matches a bare ISO OID such as 2.16.840.1.113883.
+var oidRe = regexp.MustCompile(`^[0-2](\.(0|[1-9][0-9]*))+$`)
+
+// identifierSystem returns the Identifier.system for a backend idType: the system
+// configured for it (bare OIDs become urn:oid: URIs), else urn:<idType>.
+func identifierSystem(idType string, systems map[string]string) string {
+	sys, ok := systems[strings.ToLower(strings.TrimSpace(idType))]
+	if !ok || sys == "" {
+		return "urn:" + idType
+	}
+	if oidRe.MatchString(sys) {
+		return "urn:oid:" + sys
+	}
+	return sys
+}
@@ -0,0 +1,81 @@
+package fhir
+
+import (
+	"regexp"
+	"strings"
+)
+
+// bcp47System is the code system of Patient.communication.language.
+const bcp47System = "urn:ietf:bcp:47"
+
+// languageNames maps lower-cased language names the backend uses onto BCP-47 tags.
+var languageNames = map[string]string{
+	"arabic": "ar", "english": "en", "urdu": "ur", "hindi": "hi", "bengali": "bn",
+	"tagalog": "tl", "filipino": "fil", "indonesian": "id", "malayalam": "ml",
+	"tamil": "ta", "sinhala": "si", "persian": "fa", "farsi": "fa", "turkish": "tr",
+	"french": "fr", "spanish": "es", "german": "de",
+}
+
+// languageTagRe matches a plausible BCP-47 tag such as "ar" or "en-GB".
+var languageTagRe = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// languageCode returns the BCP-47 tag for a backend language name or tag.
+func languageCode(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if code, ok := languageNames[strings.ToLower(raw)]; ok {
+		return code, true
+	}
+	if languageTagRe.MatchString(raw) {
+		primary, rest, _ := strings.Cut(raw, "-")
+		if rest != "" {
+			return strings.ToLower(primary) + "-" + strings.ToUpper(rest), true
+		}
+		return strings.ToLower(primary), true
+	}
+	return "", false
+}
+
+// mapCommunication builds Patient.communication from the backend language (kept as
+// text, coded when recognized) and marks the preferred language. An explicit backend
+// preferredLanguage/lang is marked, and added when the record doesn't list it;
+// opts.PreferredLanguage only marks a language the record already lists, since the
+// request locale says nothing about what the patient speaks.
+func mapCommunication(payload map[string]any, opts TransformOptions) []any {
+	pv := opts.placeholders()
+	var entries []any
+	var codes []string
+	if lang := pv.str(payload, "language"); lang != "" {
+		cc := map[string]any{"text": lang}
+		code, ok := languageCode(lang)
+		if ok {
+			cc["coding"] = []any{map[string]any{"system": bcp47System, "code": code}}
+		}
+		entries = append(entries, map[string]any{"language": cc})
+		codes = append(codes, code)
+	}
+	preferred, explicit := languageCode(pv.str(payload, "preferredLanguage", "lang"))
+	if !explicit {
+		var ok bool
+		if preferred, ok = languageCode(opts.PreferredLanguage); !ok {
+			return entries
+		}
+	}
+	for i, code := range codes {
+		if code != "" && primarySubtag(code) == primarySubtag(preferred) {
+			entries[i].(map[string]any)["preferred"] = true
+			return entries
+		}
+	}
+	if !explicit {
+		return entries
+	}
+	return append(entries, map[string]any{
+		"language":  map[string]any{"coding": []any{map[string]any{"system": bcp47System, "code": preferred}}},
+		"preferred": true,
+	})
+}
+
+func primarySubtag(tag string) string {
+	primary, _, _ := strings.Cut(tag, "-")
+	return primary
+}
@@ -0,0 +1,59 @@
+package fhir
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMapCommunication(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   map[string]any
+		requested string
+		want      string
+	}{
+		{
+			name:      "request locale marks the listed language",
+			payload:   map[string]any{"language": "Arabic"},
+			requested: "ar-SA",
+			want:      `[{"language":{"coding":[{"code":"ar","system":"urn:ietf:bcp:47"}],"text":"Arabic"},"preferred":true}]`,
+		},
+		{
+			name:      "request locale never adds a language",
+			payload:   map[string]any{"language": "Arabic"},
+			requested: "en",
+			want:      `[{"language":{"coding":[{"code":"ar","system":"urn:ietf:bcp:47"}],"text":"Arabic"}}]`,
+		},
+		{
+			name:      "request locale alone yields nothing",
+			payload:   map[string]any{},
+			requested: "en",
+			want:      `null`,
+		},
+		{
+			name:      "explicit backend preference is added",
+			payload:   map[string]any{"language": "Arabic", "preferredLanguage": "English"},
+			requested: "ar",
+			want: `[{"language":{"coding":[{"code":"ar","system":"urn:ietf:bcp:47"}],"text":"Arabic"}},` +
+				`{"language":{"coding":[{"code":"en","system":"urn:ietf:bcp:47"}]},"preferred":true}]`,
+		},
+		{
+			name:    "explicit backend lang marks the listed language",
+			payload: map[string]any{"language": "ar", "lang": "AR"},
+			want:    `[{"language":{"coding":[{"code":"ar","system":"urn:ietf:bcp:47"}],"text":"ar"},"preferred":true}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultTransformOptions()
+			opts.PreferredLanguage = tt.requested
+			got, err := json.Marshal(mapCommunication(tt.payload, opts))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("communication =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package fhir
+
+// GenderPrecedence selects which backend gender field wins for Patient.gender.
+type GenderPrecedence string
+
+const (
+	// GenderTextFirst prefers gender_text over the coded gender (the default).
+	GenderTextFirst GenderPrecedence = ""
+	// GenderCodeFirst prefers the coded gender over gender_text.
+	GenderCodeFirst GenderPrecedence = "code-first"
+	// GenderCodeWithText uses the coded gender and keeps gender_text in an extension.
+	GenderCodeWithText GenderPrecedence = "code-with-text"
+)
+
+// TransformOptions tunes how backend payloads are mapped onto a FHIR Patient.
+// The zero value behaves like the original, unconfigured transform.
+type TransformOptions struct {
+	// MaxPhotoBytes caps the decoded size of a base64 photo inlined into Patient.photo.data.
+	// Zero disables the cap.
+	MaxPhotoBytes int
+	// PhotoURLBase is the FHIR base used to point oversized photos at the photo endpoint
+	// (<PhotoURLBase>/Patient/<id>/photo; "/" for a root mount). When empty, oversized
+	// photos are dropped.
+	PhotoURLBase string
+	// CountryCodes maps upper-cased country names and alpha-3 codes onto ISO 3166
+	// alpha-2 for address.country. Nil keeps the raw value, upper-cased.
+	CountryCodes map[string]string
+	// PreferredLanguage is the BCP-47 language the backend record was localized in
+	// (per request); a matching Patient.communication entry is marked preferred unless
+	// the record names its own preferredLanguage/lang. It never adds an entry.
+	PreferredLanguage string
+	// Hooks run, in order, after the default mapping and before normalization, for
+	// site-specific additions and overrides.
+	Hooks []PostTransformHook
+	// SourceHashTag tags the Patient with the SHA-256 of the backend record
+	// (meta.tag system urn:empi:source-hash), so consumers can tell when the source
+	// changed even if the FHIR output didn't.
+	SourceHashTag bool
+	// EnvelopeKeys are the backend keys the record may be wrapped under, in precedence
+	// order. Nil means "data", then "details".
+	EnvelopeKeys []string
+	// Placeholders are backend values treated as empty (compared case-insensitively,
+	// blank strings always are). Nil keeps the built-in "-" and "null", which therefore
+	// also match "NULL" and "Null"; a list replaces them.
+	Placeholders []string
+	// MaxIdentifiers, MaxTelecoms and MaxAddresses cap how many of each the transform
+	// emits; extras are dropped with a warning. Zero means no cap.
+	MaxIdentifiers int
+	MaxTelecoms    int
+	MaxAddresses   int
+	// IdentifierTypes maps lower-cased backend idType values onto v2-0203 identifier
+	// types. Unmapped (or nil) types keep the raw idType as Identifier.type.text.
+	IdentifierTypes map[string]Coding
+	// IdentifierSystems maps lower-cased backend idType values onto Identifier.system
+	// URIs; a bare OID (2.16.840...) is emitted as urn:oid:<OID>. Unmapped types use
+	// urn:<idType>.
+	IdentifierSystems map[string]string
+	// ContactRelationships maps lower-cased backend contact relationships onto
+	// Patient.contact.relationship codings. Unmapped (or nil) values are text only.
+	ContactRelationships map[string]Coding
+	// ReligionCodes maps lower-cased backend religion values onto
+	// v3-ReligiousAffiliation codes. Unmapped (or nil) values are emitted as text only.
+	ReligionCodes map[string]Coding
+	// PreserveUnmapped stashes backend fields the transform doesn't map under the
+	// empi-raw extension, so no source data is silently lost.
+	PreserveUnmapped bool
+	// PhoneRegion is the ISO 3166 alpha-2 region national phone numbers belong to;
+	// when set, phone telecom values are normalized to E.164.
+	PhoneRegion string
+	// GenderPrecedence chooses between the backend gender_text and gender fields.
+	GenderPrecedence GenderPrecedence
+	// SplitFullName derives family (last token) and given names from fullName when the
+	// backend sends no discrete name parts. Off by default: conventions vary by locale.
+	SplitFullName bool
+	// ReferenceBase, when set, makes Organization/Practitioner/Patient references
+	// absolute (e.g. https://fhir.example/Organization/1). Empty keeps them relative.
+	ReferenceBase string
+}
+
+// DefaultTransformOptions returns the options used by TransformBackendToFHIRPatient.
+func DefaultTransformOptions() TransformOptions {
+	return TransformOptions{
+		MaxPhotoBytes:        256 << 10,
+		MaxIdentifiers:       50,
+		MaxTelecoms:          50,
+		MaxAddresses:         50,
+		CountryCodes:         DefaultCountryCodes(),
+		IdentifierTypes:      DefaultIdentifierTypes(),
+		ReligionCodes:        DefaultReligionCodes(),
+		ContactRelationships: DefaultContactRelationships(),
+	}
+}
@@ -0,0 +1,78 @@
+package fhir
+
+import "strings"
+
+// originalPhoneExtensionURL keeps the backend phone value when E.164 normalization changed it.
+const originalPhoneExtensionURL = "urn:empi:extension:original-phone"
+
+// callingCode describes how national numbers of a region are written.
+type callingCode struct {
+	code  string // country calling code, without "+"
+	trunk string // national trunk prefix dropped in international form
+}
+
+// callingCodes maps ISO 3166 alpha-2 regions onto their calling codes.
+var callingCodes = map[string]callingCode{
+	"SA": {"966", "0"}, "AE": {"971", "0"}, "BH": {"973", ""}, "KW": {"965", ""},
+	"OM": {"968", ""}, "QA": {"974", ""}, "JO": {"962", "0"}, "EG": {"20", "0"},
+	"GB": {"44", "0"}, "US": {"1", "1"}, "CA": {"1", "1"}, "IN": {"91", "0"},
+	"PK": {"92", "0"}, "PH": {"63", "0"}, "LK": {"94", "0"},
+}
+
+// normalizePhoneE164 rewrites a national phone number into E.164 for region. It
+// returns ok=false (leave the value alone) for unknown regions, values that already
+// are E.164, and anything that doesn't look like a phone number.
+func normalizePhoneE164(raw, region string) (string, bool) {
+	cc, known := callingCodes[strings.ToUpper(region)]
+	if !known {
+		return "", false
+	}
+	digits := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(raw))
+	if strings.HasPrefix(digits, "+") {
+		return "", false
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return "", false
+		}
+	}
+	var e164 string
+	switch {
+	case strings.HasPrefix(digits, "00"):
+		e164 = "+" + digits[2:]
+	case cc.trunk != "" && strings.HasPrefix(digits, cc.trunk):
+		e164 = "+" + cc.code + digits[len(cc.trunk):]
+	case strings.HasPrefix(digits, cc.code) && len(digits) > len(cc.code)+7:
+		e164 = "+" + digits
+	default:
+		e164 = "+" + cc.code + digits
+	}
+	// E.164 allows at most 15 digits; fewer than 8 is not a real subscriber number.
+	if n := len(e164) - 1; n < 8 || n > 15 {
+		return "", false
+	}
+	return e164, true
+}
+
+// phoneTelecom builds a phone ContactPoint, normalizing to E.164 when a region is
+// configured and keeping the original value in an extension if it changed.
+func phoneTelecom(value string, opts TransformOptions) map[string]any {
+	cp := map[string]any{"system": "phone", "value": value}
+	if opts.PhoneRegion == "" {
+		return cp
+	}
+	if e164, ok := normalizePhoneE164(value, opts.PhoneRegion); ok && e164 != value {
+		cp["value"] = e164
+		cp["_value"] = map[string]any{"extension": []any{map[string]any{
+			"url":         originalPhoneExtensionURL,
+			"valueString": value,
+		}}}
+	}
+	return cp
+}
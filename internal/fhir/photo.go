@@ -0,0 +1,84 @@
+package fhir
+
+import (
+	"errors"
+)
+
+// Backend keys carrying the patient photo, in order of preference.
+var (
+	photoURLKeys         = []string{"photoUrl", "avatarUrl", "imageUrl", "pictureUrl"}
+	photoDataKeys        = []string{"photoBase64", "avatarBase64", "imageBase64", "imageData", "photo"}
+	photoContentTypeKeys = []string{"photoContentType", "imageContentType", "contentType"}
+)
+
+// ErrNoPhoto is returned by ExtractPatientPhoto when the record carries no photo.
+var ErrNoPhoto = errors.New("patient has no photo")
+
+// Photo is a patient photo extracted from a backend (or already-FHIR) payload.
+// Exactly one of Data or URL is set.
+type Photo struct {
+	Data        []byte
+	URL         string
+	ContentType string
+}
+
+// ExtractPatientPhoto returns the first photo of the record in beJSON, decoding
+// base64 content. It understands both the backend photo fields and Patient.photo
+// of an already-FHIR payload.
+func ExtractPatientPhoto(beJSON []byte) (*Photo, error) {
+	return ExtractPatientPhotoWithOptions(beJSON, DefaultTransformOptions())
+}
+
+// ExtractPatientPhotoWithOptions is ExtractPatientPhoto honoring opts.EnvelopeKeys.
+func ExtractPatientPhotoWithOptions(beJSON []byte, opts TransformOptions) (*Photo, error) {
+	pv := opts.placeholders()
+	payload, err := unwrapPayload(beJSON, opts.EnvelopeKeys)
+	if err != nil {
+		return nil, err
+	}
+	// Already-FHIR shape: photo is an array of Attachments.
+	if list, ok := payload["photo"].([]any); ok {
+		for _, item := range list {
+			att, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			if p, err := photoFromFields(att, pv, []string{"url"}, []string{"data"}, []string{"contentType"}); p != nil || err != nil {
+				return p, err
+			}
+		}
+		return nil, ErrNoPhoto
+	}
+	p, err := photoFromFields(payload, pv, photoURLKeys, photoDataKeys, photoContentTypeKeys)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ErrNoPhoto
+	}
+	return p, nil
+}
+
+// photoFromFields reads a photo from m using the given url, base64 and content-type keys.
+// It returns nil, nil when none of the keys are present.
+func photoFromFields(m map[string]any, pv placeholders, urlKeys, dataKeys, ctKeys []string) (*Photo, error) {
+	ct := pv.str(m, ctKeys...)
+	if u := pv.str(m, urlKeys...); u != "" {
+		if ct == "" {
+			ct = guessImageContentType(u)
+		}
+		return &Photo{URL: u, ContentType: ct}, nil
+	}
+	b64 := pv.str(m, dataKeys...)
+	if b64 == "" {
+		return nil, nil
+	}
+	raw, err := decodePhoto(b64)
+	if err != nil {
+		return nil, err
+	}
+	if ct == "" {
+		ct = sniffImageContentType(raw)
+	}
+	return &Photo{Data: raw, ContentType: ct}, nil
+}
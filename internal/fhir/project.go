@@ -0,0 +1,92 @@
+package fhir
+
+import (
+	"fmt"
+
+	fhirversion "github.com/google/fhir/go/fhirversion"
+	jsonformat "github.com/google/fhir/go/jsonformat"
+	dtpb "github.com/google/fhir/go/proto/google/fhir/proto/r4/core/datatypes_go_proto"
+	patientpb "github.com/google/fhir/go/proto/google/fhir/proto/r4/core/resources/patient_go_proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// subsettedSystem/Code mark a resource that was returned with elements omitted.
+const (
+	subsettedSystem = "http://terminology.hl7.org/CodeSystem/v3-ObservationValue"
+	subsettedCode   = "SUBSETTED"
+)
+
+// alwaysKept are Patient elements a projection never removes: the id/meta needed to
+// identify the resource and the modifier elements whose removal would change its meaning.
+var alwaysKept = map[string]bool{"id": true, "meta": true, "implicitRules": true, "modifierExtension": true}
+
+// PatientSummaryElements are the Patient elements flagged isSummary in R4 (_summary=true).
+var PatientSummaryElements = []string{
+	"identifier", "active", "name", "telecom", "gender", "birthDate",
+	"deceased", "address", "managingOrganization", "link",
+}
+
+// PatientDataElements are all Patient elements except the narrative (_summary=data).
+var PatientDataElements = patientElementsExcept("text")
+
+// patientElementsExcept lists the JSON names of the R4 Patient elements, less omit.
+func patientElementsExcept(omit string) []string {
+	fields := (&patientpb.Patient{}).ProtoReflect().Descriptor().Fields()
+	elements := make([]string, 0, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		if name := fields.Get(i).JSONName(); name != omit {
+			elements = append(elements, name)
+		}
+	}
+	return elements
+}
+
+// ProjectPatient keeps only the given top-level elements of an R4 Patient (plus the
+// mandatory/modifier ones) and tags the result SUBSETTED. Elements are JSON names,
+// with choice types named without their suffix ("deceased"). Pruning happens on the
+// typed google/fhir model, so the result is always a structurally valid Patient.
+func ProjectPatient(data []byte, elements []string) ([]byte, error) {
+	cr, err := unmarshalR4(data)
+	if err != nil {
+		return nil, err
+	}
+	patient := cr.GetPatient()
+	if patient == nil {
+		return nil, fmt.Errorf("resource is not a Patient")
+	}
+	keep := make(map[string]bool, len(elements))
+	for _, e := range elements {
+		keep[e] = true
+	}
+	pm := patient.ProtoReflect()
+	var drop []protoreflect.FieldDescriptor
+	pm.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		name := fd.JSONName()
+		if !keep[name] && !alwaysKept[name] {
+			drop = append(drop, fd)
+		}
+		return true
+	})
+	for _, fd := range drop {
+		pm.Clear(fd)
+	}
+	if patient.Meta == nil {
+		patient.Meta = &dtpb.Meta{}
+	}
+	patient.Meta.Tag = append(patient.Meta.Tag, &dtpb.Coding{
+		System: &dtpb.Uri{Value: subsettedSystem},
+		Code:   &dtpb.Code{Value: subsettedCode},
+	})
+	m, err := jsonformat.NewMarshaller(false, "", "", fhirversion.R4)
+	if err != nil {
+		return nil, err
+	}
+	out, err := m.Marshal(cr)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidatePatientR4(out); err != nil {
+		return nil, fmt.Errorf("projected Patient failed validation: %w", err)
+	}
+	return out, nil
+}
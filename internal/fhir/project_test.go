@@ -0,0 +1,30 @@
+package fhir
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestProjectPatientData(t *testing.T) {
+	in := `{"resourceType":"Patient","id":"123","text":{"status":"generated","div":"<div xmlns=\"http://www.w3.org/1999/xhtml\">John</div>"},` +
+		`"name":[{"family":"Doe"}],"gender":"male","maritalStatus":{"text":"2"}}`
+	out, err := ProjectPatient([]byte(in), PatientDataElements)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p map[string]any
+	if err := json.Unmarshal(out, &p); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p["text"]; ok {
+		t.Errorf("_summary=data kept the narrative: %s", out)
+	}
+	for _, e := range []string{"name", "gender", "maritalStatus"} {
+		if _, ok := p[e]; !ok {
+			t.Errorf("_summary=data dropped %s: %s", e, out)
+		}
+	}
+	if got := compact(t, p["meta"]); got != `{"tag":[{"code":"SUBSETTED","system":"`+subsettedSystem+`"}]}` {
+		t.Errorf("meta = %s", got)
+	}
+}
@@ -0,0 +1,41 @@
+package fhir
+
+import (
+	"expvar"
+	"regexp"
+)
+
+// DataQuality counts transform steps that produced no output and generated Patients
+// that failed validation, published via expvar (/debug/vars) as fhir_data_quality.
+// Keys are a fixed set so dashboards can alert on spikes:
+//
+//	missing_name, unparseable_birth_date, unmapped_gender, invalid_reference,
+//	invalid_photo, oversized_photo, truncated_<element>, validation_failed.<element>
+var DataQuality = expvar.NewMap("fhir_data_quality")
+
+// validationElements bounds the element label of validation_failed counts; anything
+// else is counted as "other".
+var validationElements = map[string]bool{
+	"id": true, "meta": true, "extension": true, "identifier": true, "active": true,
+	"name": true, "telecom": true, "gender": true, "birthDate": true, "deceasedBoolean": true,
+	"address": true, "maritalStatus": true, "photo": true, "contact": true,
+	"communication": true, "generalPractitioner": true, "managingOrganization": true, "link": true,
+}
+
+// validationPathRe pulls the top-level element out of a google/fhir error such as
+// `error at "Patient.name[0].given[0]": expected string`.
+var validationPathRe = regexp.MustCompile(`"Patient\.([A-Za-z]+)`)
+
+// countQuality increments a data-quality counter.
+func countQuality(key string) {
+	DataQuality.Add(key, 1)
+}
+
+// countValidationFailure counts a failed validation under the element it names.
+func countValidationFailure(err error) {
+	element := "other"
+	if m := validationPathRe.FindStringSubmatch(err.Error()); m != nil && validationElements[m[1]] {
+		element = m[1]
+	}
+	countQuality("validation_failed." + element)
+}
@@ -0,0 +1,77 @@
+package fhir
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// rawExtensionURL holds backend fields the transform doesn't map, one sub-extension
+// per field keyed by the backend field name.
+const rawExtensionURL = "urn:empi:extension:empi-raw"
+
+// mappedBackendKeys are the backend fields the transform maps without loss. Anything
+// else (including lossy mappings such as fileStatus -> active) is kept by
+// TransformOptions.PreserveUnmapped. Keep this in sync when adding mappings.
+var mappedBackendKeys = map[string]bool{
+	"addresses": true, "area": true, "city": true, "country": true, "street": true, "zipCode": true,
+	"fullAddress": true, "formattedAddress": true, "addressText": true, "addressType": true,
+	"addressValidFrom": true, "addressValidTo": true,
+	"dateOfBirth": true, "email": true, "mobileNumber": true, "phoneNumber": true,
+	"emergencyContactEmail": true, "emergencyContactFirstName": true, "emergencyContactFirstNameLocal": true,
+	"emergencyContactLastName": true, "emergencyContactLastNameLocal": true, "emergencyContactName": true,
+	"emergencyContactPhoneNumber": true, "emergencyContactRelationship": true, "contacts": true, "relatedPersons": true,
+	"firstName": true, "givenName": true, "givenNames": true, "middle": true, "middleName": true,
+	"thirdName": true, "lastName": true, "familyName": true, "fullName": true,
+	"names": true, "nameType": true, "nameUse": true, "nameValidFrom": true, "nameValidTo": true,
+	"gender": true, "gender_text": true, "isDeceased": true, "language": true, "preferredLanguage": true, "lang": true,
+	"maritalStatus": true, "maritialStatus": true, "nationality": true, "nationalityCode": true, "religion": true,
+	"id": true, "idNumber": true, "idType": true, "legacyMRN": true, "medicalRecordNumber": true,
+	"patientId": true, "patientNumber": true, "upi": true, "linkedParentUpi": true,
+	"isClosed": true, "closed": true, "isMerged": true, "mergedIntoUpi": true, "mergedInto": true, "mergedUpis": true, "mergedFromUpis": true,
+	"hospitalId": true, "registeredAt": true, "primaryHealthcareCenter": true, "primaryHealthcarePhysician": true,
+	"hospitalName": true, "registeredAtName": true, "primaryHealthcareCenterName": true, "primaryHealthcarePhysicianName": true,
+	"photoUrl": true, "avatarUrl": true, "imageUrl": true, "pictureUrl": true,
+	"photoBase64": true, "avatarBase64": true, "imageBase64": true, "imageData": true, "photo": true,
+	"photoContentType": true, "imageContentType": true, "contentType": true,
+	"photoTitle": true, "photoCreatedOn": true, "photoCreation": true, "createdOn": true, "modifiedOn": true,
+}
+
+// rawExtension stashes the unmapped fields of payload as key/value sub-extensions.
+// It returns nil when every non-empty field was mapped.
+func rawExtension(payload map[string]any, pv placeholders) map[string]any {
+	keys := make([]string, 0, len(payload))
+	for k := range payload {
+		if !mappedBackendKeys[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	subs := make([]any, 0, len(keys))
+	for _, k := range keys {
+		var sub map[string]any
+		switch v := payload[k].(type) {
+		case bool:
+			sub = map[string]any{"url": k, "valueBoolean": v}
+		case float64:
+			sub = map[string]any{"url": k, "valueString": strconv.FormatFloat(v, 'f', -1, 64)}
+		case string:
+			if s := pv.str(payload, k); s != "" {
+				sub = map[string]any{"url": k, "valueString": s}
+			}
+		case nil:
+		default:
+			// Nested objects/arrays are kept as compact JSON text.
+			if b, err := json.Marshal(v); err == nil && string(b) != "{}" && string(b) != "[]" {
+				sub = map[string]any{"url": k, "valueString": string(b)}
+			}
+		}
+		if sub != nil {
+			subs = append(subs, sub)
+		}
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+	return map[string]any{"url": rawExtensionURL, "extension": subs}
+}
@@ -0,0 +1,194 @@
+package fhir
+
+import (
+	"encoding/json"
+	"fmt"
+
+	fhirversion "github.com/google/fhir/go/fhirversion"
+	jsonformat "github.com/google/fhir/go/jsonformat"
+	dtpb "github.com/google/fhir/go/proto/google/fhir/proto/r4/core/datatypes_go_proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// redactedCode marks a resource that had sensitive elements removed (meta.security).
+const redactedCode = "REDACTED"
+
+// Redaction lists the Patient content withheld from consumers not cleared to see it.
+type Redaction struct {
+	// Elements are top-level Patient elements to omit, by JSON name (e.g. "telecom",
+	// "address", "contact"). Elements a projection always keeps can't be redacted.
+	Elements []string
+	// IdentifierSystems drops the identifiers with these systems (e.g. the national id).
+	IdentifierSystems []string
+}
+
+// Empty reports whether the redaction removes nothing.
+func (rd Redaction) Empty() bool {
+	return len(rd.Elements) == 0 && len(rd.IdentifierSystems) == 0
+}
+
+// Redacts reports whether the top-level Patient element is withheld.
+func (rd Redaction) Redacts(element string) bool {
+	for _, e := range rd.Elements {
+		if e == element && !alwaysKept[e] {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactPatient removes the elements rd lists from an R4 Patient. When anything was
+// removed the result is labelled REDACTED in meta.security and redacted is true;
+// otherwise data is returned unchanged.
+func RedactPatient(data []byte, rd Redaction) (out []byte, redacted bool, err error) {
+	if rd.Empty() {
+		return data, false, nil
+	}
+	cr, err := unmarshalR4(data)
+	if err != nil {
+		return nil, false, err
+	}
+	patient := cr.GetPatient()
+	if patient == nil {
+		return nil, false, fmt.Errorf("resource is not a Patient")
+	}
+	drop := make(map[string]bool, len(rd.Elements))
+	for _, e := range rd.Elements {
+		drop[e] = !alwaysKept[e]
+	}
+	pm := patient.ProtoReflect()
+	var clear []protoreflect.FieldDescriptor
+	pm.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		if drop[fd.JSONName()] {
+			clear = append(clear, fd)
+		}
+		return true
+	})
+	for _, fd := range clear {
+		pm.Clear(fd)
+	}
+	redacted = len(clear) > 0
+	if len(rd.IdentifierSystems) > 0 {
+		systems := make(map[string]bool, len(rd.IdentifierSystems))
+		for _, s := range rd.IdentifierSystems {
+			systems[s] = true
+		}
+		kept := patient.Identifier[:0]
+		for _, id := range patient.Identifier {
+			if systems[id.GetSystem().GetValue()] {
+				redacted = true
+				continue
+			}
+			kept = append(kept, id)
+		}
+		patient.Identifier = kept
+	}
+	if !redacted {
+		return data, false, nil
+	}
+	if patient.Meta == nil {
+		patient.Meta = &dtpb.Meta{}
+	}
+	patient.Meta.Security = append(patient.Meta.Security, &dtpb.Coding{
+		System: &dtpb.Uri{Value: subsettedSystem},
+		Code:   &dtpb.Code{Value: redactedCode},
+	})
+	m, err := jsonformat.NewMarshaller(false, "", "", fhirversion.R4)
+	if err != nil {
+		return nil, false, err
+	}
+	if out, err = m.Marshal(cr); err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// backendElementKeys are the backend fields each Patient element is mapped from, so
+// RedactBackend can withhold them from the raw record. Keep in sync with the transform.
+var backendElementKeys = map[string][]string{
+	"identifier": {"legacyMRN", "medicalRecordNumber", "patientNumber", "upi", "patientId", "id", "idType", "idNumber"},
+	"active":     {"fileStatus", "isClosed", "closed", "isMerged", "mergedIntoUpi", "mergedInto"},
+	"name": {"names", "firstName", "givenName", "givenNames", "middleName", "middle", "thirdName", "lastName",
+		"familyName", "fullName", "nameUse", "nameType", "nameValidFrom", "nameValidTo"},
+	"gender":        {"gender", "gender_text"},
+	"birthDate":     {"dateOfBirth"},
+	"deceased":      {"isDeceased"},
+	"maritalStatus": {"maritialStatus", "maritalStatus"},
+	"communication": {"language", "preferredLanguage", "lang"},
+	"telecom":       {"mobileNumber", "phoneNumber", "email"},
+	"address": {"addresses", "fullAddress", "formattedAddress", "addressText", "addressType", "addressValidFrom",
+		"addressValidTo", "street", "city", "area", "zipCode", "country"},
+	"managingOrganization": {"registeredAt", "registeredAtName", "hospitalId", "hospitalName"},
+	"generalPractitioner": {"primaryHealthcarePhysician", "primaryHealthcarePhysicianName",
+		"primaryHealthcareCenter", "primaryHealthcareCenterName"},
+	"link": {"linkedParentUpi", "mergedIntoUpi", "mergedInto", "mergedUpis", "mergedFromUpis"},
+	"contact": {"contacts", "relatedPersons", "emergencyContactName", "emergencyContactFirstName",
+		"emergencyContactFirstNameLocal", "emergencyContactLastName", "emergencyContactLastNameLocal",
+		"emergencyContactPhoneNumber", "emergencyContactEmail", "emergencyContactRelationship"},
+	"photo": append(append(append([]string{"photoTitle", "photoCreatedOn", "photoCreation", "createdOn", "modifiedOn"},
+		photoURLKeys...), photoDataKeys...), photoContentTypeKeys...),
+	// extension also carries every unmapped field (PreserveUnmapped); see RedactBackend.
+	"extension": {"nationality", "nationalityCode", "religion"},
+}
+
+// RedactBackend applies rd to a raw backend record, returning the unwrapped record
+// without the fields the redacted elements and identifiers are mapped from. It fails
+// rather than guess when it can't tell which fields feed a redacted element.
+func RedactBackend(beJSON []byte, rd Redaction, opts TransformOptions) ([]byte, error) {
+	pv := opts.placeholders()
+	if rd.Empty() {
+		return beJSON, nil
+	}
+	payload, err := unwrapPayload(beJSON, opts.EnvelopeKeys)
+	if err != nil {
+		return nil, err
+	}
+	if rt, _ := payload["resourceType"].(string); rt == "Patient" {
+		// Already FHIR: the Patient redaction applies as-is.
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		out, _, err := RedactPatient(b, rd)
+		return out, err
+	}
+	if len(opts.Hooks) > 0 {
+		return nil, fmt.Errorf("post-transform hooks may map any backend field")
+	}
+	for _, e := range rd.Elements {
+		if !rd.Redacts(e) {
+			continue
+		}
+		keys, ok := backendElementKeys[e]
+		if !ok {
+			return nil, fmt.Errorf("no backend fields known for element %q", e)
+		}
+		for _, k := range keys {
+			delete(payload, k)
+		}
+		if e == "extension" {
+			for k := range payload {
+				if !mappedBackendKeys[k] {
+					delete(payload, k)
+				}
+			}
+		}
+	}
+	for _, system := range rd.IdentifierSystems {
+		switch system {
+		case "urn:mrn":
+			delete(payload, "legacyMRN")
+			delete(payload, "medicalRecordNumber")
+			delete(payload, "patientNumber")
+		case "urn:upi":
+			delete(payload, "upi")
+			delete(payload, "patientId")
+			delete(payload, "id")
+		}
+		if idType := pv.str(payload, "idType"); idType != "" && identifierSystem(idType, opts.IdentifierSystems) == system {
+			delete(payload, "idType")
+			delete(payload, "idNumber")
+		}
+	}
+	return json.Marshal(payload)
+}
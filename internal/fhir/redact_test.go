@@ -0,0 +1,76 @@
+package fhir
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedactPatient(t *testing.T) {
+	in, err := TransformBackendToFHIRPatient([]byte(`{"data":{"upi":"123","firstName":"John",`+
+		`"mobileNumber":"0501234567","idType":"national","idNumber":"1010101010"}}`), "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, redacted, err := RedactPatient(in, Redaction{Elements: []string{"telecom", "id"}, IdentifierSystems: []string{"urn:national"}})
+	if err != nil || !redacted {
+		t.Fatalf("redacted=%v err=%v", redacted, err)
+	}
+	s := string(out)
+	for _, gone := range []string{"0501234567", "1010101010"} {
+		if strings.Contains(s, gone) {
+			t.Errorf("%s survived redaction: %s", gone, s)
+		}
+	}
+	for _, kept := range []string{`"id":"123"`, `"system":"urn:upi"`, `"code":"REDACTED"`} {
+		if !strings.Contains(s, kept) {
+			t.Errorf("%s missing after redaction: %s", kept, s)
+		}
+	}
+	if same, redacted, _ := RedactPatient(in, Redaction{Elements: []string{"photo"}}); redacted || string(same) != string(in) {
+		t.Errorf("redaction of an absent element changed the Patient")
+	}
+}
+
+func TestRedactBackend(t *testing.T) {
+	be := []byte(`{"data":{"upi":"123","legacyMRN":"M1","firstName":"John","mobileNumber":"0501234567",` +
+		`"email":"j@example.com","idType":"national","idNumber":"1010101010","nationality":"SA","shoeSize":"42"}}`)
+	opts := DefaultTransformOptions()
+	opts.IdentifierSystems = map[string]string{"national": "2.16.840.1.113883.3.1"}
+	tests := []struct {
+		name string
+		rd   Redaction
+		gone []string
+		kept []string
+	}{
+		{"elements", Redaction{Elements: []string{"telecom"}}, []string{"mobileNumber", "email"}, []string{"firstName", "idNumber"}},
+		{"configured identifier system", Redaction{IdentifierSystems: []string{"urn:oid:2.16.840.1.113883.3.1"}}, []string{"idNumber", "idType"}, []string{"upi", "legacyMRN"}},
+		{"mrn", Redaction{IdentifierSystems: []string{"urn:mrn"}}, []string{"legacyMRN"}, []string{"upi", "idNumber"}},
+		{"extension drops unmapped fields", Redaction{Elements: []string{"extension"}}, []string{"nationality", "shoeSize"}, []string{"upi"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := RedactBackend(be, tt.rd, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(out, &got); err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range tt.gone {
+				if _, ok := got[k]; ok {
+					t.Errorf("%s survived redaction: %s", k, out)
+				}
+			}
+			for _, k := range tt.kept {
+				if _, ok := got[k]; !ok {
+					t.Errorf("%s missing after redaction: %s", k, out)
+				}
+			}
+		})
+	}
+	if _, err := RedactBackend(be, Redaction{Elements: []string{"multipleBirth"}}, opts); err == nil {
+		t.Error("element without known backend fields redacted without error")
+	}
+}
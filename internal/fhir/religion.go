@@ -0,0 +1,43 @@
+package fhir
+
+const (
+	religionExtensionURL         = "http://hl7.org/fhir/StructureDefinition/patient-religion"
+	v3ReligiousAffiliationSystem = "http://terminology.hl7.org/CodeSystem/v3-ReligiousAffiliation"
+)
+
+// DefaultReligionCodes returns the default table mapping lower-cased backend religion
+// values onto v3-ReligiousAffiliation codes.
+func DefaultReligionCodes() map[string]Coding {
+	islam := Coding{v3ReligiousAffiliationSystem, "1023", "Islam"}
+	christian := Coding{v3ReligiousAffiliationSystem, "1013", "Christian (non-Catholic, non-specific)"}
+	return map[string]Coding{
+		"islam":          islam,
+		"muslim":         islam,
+		"sunni":          {v3ReligiousAffiliationSystem, "1049", "Sunni (Islam)"},
+		"shia":           {v3ReligiousAffiliationSystem, "1045", "Shiite (Islam)"},
+		"shiite":         {v3ReligiousAffiliationSystem, "1045", "Shiite (Islam)"},
+		"christian":      christian,
+		"christianity":   christian,
+		"catholic":       {v3ReligiousAffiliationSystem, "1041", "Roman Catholic Church"},
+		"roman catholic": {v3ReligiousAffiliationSystem, "1041", "Roman Catholic Church"},
+		"orthodox":       {v3ReligiousAffiliationSystem, "1036", "Orthodox"},
+		"hindu":          {v3ReligiousAffiliationSystem, "1020", "Hinduism"},
+		"hinduism":       {v3ReligiousAffiliationSystem, "1020", "Hinduism"},
+		"jewish":         {v3ReligiousAffiliationSystem, "1026", "Judaism"},
+		"judaism":        {v3ReligiousAffiliationSystem, "1026", "Judaism"},
+		"sikh":           {v3ReligiousAffiliationSystem, "1047", "Sikism"},
+		"atheist":        {v3ReligiousAffiliationSystem, "1007", "Atheism"},
+		"agnostic":       {v3ReligiousAffiliationSystem, "1004", "Agnosticism"},
+	}
+}
+
+// religionExtension builds the patient-religion extension from the backend religion,
+// coded when opts.ReligionCodes maps it and always keeping the raw text.
+func religionExtension(payload map[string]any, opts TransformOptions) (map[string]any, bool) {
+	pv := opts.placeholders()
+	raw := pv.str(payload, "religion")
+	if raw == "" {
+		return nil, false
+	}
+	return map[string]any{"url": religionExtensionURL, "valueCodeableConcept": codedText(raw, opts.ReligionCodes)}, true
+}
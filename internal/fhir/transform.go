@@ -1,49 +1,45 @@
 package fhir
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
-
-	fhirversion "github.com/google/fhir/go/fhirversion"
-	jsonformat "github.com/google/fhir/go/jsonformat"
+	"time"
 )
 
 // TransformBackendToFHIRPatient transforms the backend EMPI payload into a FHIR R4 Patient JSON.
 // pathID is used to set/override the Patient.id.
 func TransformBackendToFHIRPatient(beJSON []byte, pathID string) ([]byte, error) {
+	return TransformBackendToFHIRPatientWithOptions(beJSON, pathID, DefaultTransformOptions())
+}
+
+// TransformBackendToFHIRPatientWithOptions is TransformBackendToFHIRPatient with explicit options.
+func TransformBackendToFHIRPatientWithOptions(beJSON []byte, pathID string, opts TransformOptions) ([]byte, error) {
 	// If payload is already a FHIR Patient, return as-is.
 	if LooksLikePatient(beJSON) {
 		return beJSON, nil
 	}
-	// Unwrap common envelope shapes: {"details": {...}} or {"data": "<json>"} or {"data": {...}}
-	var anyMap map[string]any
-	if err := json.Unmarshal(beJSON, &anyMap); err != nil {
+	payload, err := unwrapPayload(beJSON, opts.EnvelopeKeys)
+	if err != nil {
 		return nil, err
 	}
-	payload := anyMap
-	if d, ok := anyMap["details"]; ok {
-		if m, ok := d.(map[string]any); ok {
-			payload = m
-		}
-	}
-	if d, ok := anyMap["data"]; ok {
-		switch v := d.(type) {
-		case string:
-			var inner map[string]any
-			if err := json.Unmarshal([]byte(v), &inner); err == nil {
-				payload = inner
-			}
-		case map[string]any:
-			payload = v
-		}
+	pv := opts.placeholders()
+	var sourceHash string
+	if opts.SourceHashTag {
+		sourceHash = payloadHash(payload)
 	}
 	// If unwrapped content itself is FHIR Patient, return it.
-	if b, err := json.Marshal(payload); err == nil {
-		if LooksLikePatient(b) {
+	if rt, _ := payload["resourceType"].(string); rt == "Patient" {
+		if b, err := json.Marshal(payload); err == nil && LooksLikePatient(b) {
 			return b, nil
 		}
 	}
@@ -53,183 +49,233 @@ func TransformBackendToFHIRPatient(beJSON []byte, pathID string) ([]byte, error)
 		"resourceType": "Patient",
 		"id":           pathID,
 	}
-	// active
-	if s := str(payload, "fileStatus"); s != "" {
-		patient["active"] = strings.EqualFold(s, "active")
-	}
-	// identifier(s)
-	identifiers := make([]any, 0, 3)
-	if v := str(payload, "legacyMRN", "medicalRecordNumber", "patientNumber"); v != "" {
-		identifiers = append(identifiers, map[string]any{"system": "urn:mrn", "value": v})
+	// active; closed files (reads include them via includeClosed=true) are also tagged
+	// so clients can tell archived patients from merely inactive ones.
+	fileStatus := pv.str(payload, "fileStatus")
+	if fileStatus != "" {
+		patient["active"] = strings.EqualFold(fileStatus, "active")
 	}
-	if v := str(payload, "upi", "patientId", "id"); v != "" {
-		identifiers = append(identifiers, map[string]any{"system": "urn:upi", "value": v})
+	if closed, _ := pv.boolv(payload, "isClosed", "closed"); closed || isClosedFileStatus(fileStatus) {
+		patient["active"] = false
+		addMetaTag(patient, map[string]any{"system": fileStatusTagSystem, "code": "closed", "display": "Closed file"})
 	}
-	if idType := str(payload, "idType"); idType != "" {
-		if idNum := str(payload, "idNumber"); idNum != "" {
-			identifiers = append(identifiers, map[string]any{"system": "urn:" + idType, "value": idNum})
+	// identifier(s): the MRN is the usual identifier, the UPI and government ids official.
+	identifiers := make([]any, 0, 3)
+	if v := pv.str(payload, "legacyMRN", "medicalRecordNumber", "patientNumber"); v != "" {
+		identifiers = append(identifiers, map[string]any{
+			"use": "usual", "type": codeableConcept(mrnIdentifierType), "system": "urn:mrn", "value": v,
+		})
+	}
+	if v := pv.str(payload, "upi", "patientId", "id"); v != "" {
+		identifiers = append(identifiers, map[string]any{"use": "official", "system": "urn:upi", "value": v})
+	}
+	if idType := pv.str(payload, "idType"); idType != "" {
+		if idNum := pv.str(payload, "idNumber"); idNum != "" {
+			identifiers = append(identifiers, map[string]any{
+				"use": "official", "type": identifierType(idType, opts.IdentifierTypes), "system": identifierSystem(idType, opts.IdentifierSystems), "value": idNum,
+			})
 		}
 	}
 	if len(identifiers) > 0 {
-		patient["identifier"] = identifiers
-	}
-	// name
-	first := str(payload, "firstName", "givenName")
-	middle := str(payload, "middleName", "middle")
-	third := str(payload, "thirdName")
-	last := str(payload, "lastName", "familyName")
-	full := str(payload, "fullName")
-	givens := filterNonEmpty(first, middle, third)
-	name := map[string]any{}
-	if last != "" {
-		name["family"] = last
-	}
-	if len(givens) > 0 {
-		name["given"] = givens
+		patient["identifier"] = capEntries(pathID, "identifier", sortBySystemValue(dedupeEntries(identifiers)), opts.MaxIdentifiers)
+	}
+	// name: a backend names array maps to several HumanNames, else the flat fields to one.
+	names := make([]any, 0, 1)
+	if list, ok := payload["names"].([]any); ok {
+		for _, item := range list {
+			if m, ok := item.(map[string]any); ok {
+				if name := mapName(m, opts); len(name) > 0 {
+					names = append(names, name)
+				}
+			}
+		}
 	}
-	if full != "" {
-		name["text"] = full
+	if len(names) == 0 {
+		if name := mapName(payload, opts); len(name) > 0 {
+			names = append(names, name)
+		}
 	}
-	if len(name) > 0 {
-		patient["name"] = []any{name}
+	if len(names) > 0 {
+		patient["name"] = names
+	} else {
+		countQuality("missing_name")
 	}
 	// gender
-	if gtxt := str(payload, "gender_text"); gtxt != "" {
-		patient["gender"] = normalizeGender(gtxt)
-	} else if g := str(payload, "gender"); g != "" {
-		patient["gender"] = normalizeGender(g)
-	}
+	mapGender(patient, payload, opts.GenderPrecedence, pv)
 	// birthDate
-	if dob := str(payload, "dateOfBirth"); dob != "" {
-		patient["birthDate"] = normalizeDate(dob)
+	if dob := pv.str(payload, "dateOfBirth"); dob != "" {
+		if date, birthTime := normalizeBirthDate(dob); date != "" {
+			patient["birthDate"] = date
+			if birthTime != "" {
+				patient["_birthDate"] = map[string]any{"extension": []any{map[string]any{
+					"url":           birthTimeExtensionURL,
+					"valueDateTime": birthTime,
+				}}}
+			}
+		} else {
+			log.Printf("Dropping unparseable dateOfBirth=%q", dob)
+			countQuality("unparseable_birth_date")
+		}
 	}
 	// maritalStatus: return the raw BE value (e.g., "2") as text only
-	if ms := str(payload, "maritialStatus", "maritalStatus"); ms != "" {
+	if ms := pv.str(payload, "maritialStatus", "maritalStatus"); ms != "" {
 		patient["maritalStatus"] = map[string]any{
 			"text": ms,
 		}
 	}
-	// communication: show raw BE 'language' value as text (no code mapping yet)
-	if lang := str(payload, "language"); lang != "" {
-		patient["communication"] = []any{
-			map[string]any{
-				"language": map[string]any{"text": lang},
-			},
-		}
+	// communication: the BE 'language' value, plus the preferred (record) language
+	if comm := mapCommunication(payload, opts); len(comm) > 0 {
+		patient["communication"] = comm
 	}
 	// deceasedBoolean
-	if db, ok := boolv(payload, "isDeceased"); ok {
+	if db, ok := pv.boolv(payload, "isDeceased"); ok {
 		patient["deceasedBoolean"] = db
 	}
 	// telecom
 	telecom := make([]any, 0, 2)
-	if ph := str(payload, "mobileNumber", "phoneNumber"); ph != "" {
-		telecom = append(telecom, map[string]any{"system": "phone", "value": ph})
+	if ph := pv.str(payload, "mobileNumber", "phoneNumber"); ph != "" {
+		telecom = append(telecom, phoneTelecom(ph, opts))
 	}
-	if em := str(payload, "email"); em != "" {
+	if em := pv.str(payload, "email"); em != "" {
 		telecom = append(telecom, map[string]any{"system": "email", "value": em})
 	}
 	if len(telecom) > 0 {
-		patient["telecom"] = telecom
-	}
-	// address
-	addr := map[string]any{}
-	lines := filterNonEmpty(str(payload, "street"))
-	if len(lines) > 0 {
-		addr["line"] = lines
-	}
-	if city := str(payload, "city"); city != "" {
-		addr["city"] = city
-	}
-	if state := str(payload, "area"); state != "" {
-		addr["state"] = state
-	}
-	if pc := str(payload, "zipCode"); pc != "" {
-		addr["postalCode"] = pc
+		patient["telecom"] = capEntries(pathID, "telecom", sortBySystemValue(dedupeEntries(telecom)), opts.MaxTelecoms)
+	}
+	// address: a backend addresses array, plus the flat address fields
+	addresses := make([]any, 0, 1)
+	list, _ := payload["addresses"].([]any)
+	for _, item := range list {
+		if m, ok := item.(map[string]any); ok {
+			if addr := mapAddress(m, opts); len(addr) > 0 {
+				addresses = append(addresses, addr)
+			}
+		}
 	}
-	if country := str(payload, "country"); country != "" {
-		addr["country"] = strings.ToUpper(country)
+	if addr := mapAddress(payload, opts); len(addr) > 0 {
+		addresses = append(addresses, addr)
 	}
-	if len(addr) > 0 {
-		patient["address"] = []any{addr}
+	if len(addresses) > 0 {
+		patient["address"] = capEntries(pathID, "address", dedupeEntries(addresses), opts.MaxAddresses)
 	}
-	// managingOrganization: prefer registeredAt, else hospitalId
-	if orgID := str(payload, "registeredAt", "hospitalId"); orgID != "" {
-		patient["managingOrganization"] = map[string]any{
-			"reference": "Organization/" + orgID,
+	// managingOrganization: prefer registeredAt, else hospitalId (display from the matching name).
+	// A registeredAt that isn't a valid id falls back to hospitalId.
+	for _, k := range [][2]string{{"registeredAt", "registeredAtName"}, {"hospitalId", "hospitalName"}} {
+		if orgID := pv.str(payload, k[0]); orgID != "" {
+			if ref, ok := reference(opts, "Organization", orgID, pv.str(payload, k[1])); ok {
+				patient["managingOrganization"] = ref
+				break
+			}
 		}
 	}
 	// generalPractitioner
 	gp := make([]any, 0, 2)
-	if pid := str(payload, "primaryHealthcarePhysician"); pid != "" {
-		gp = append(gp, map[string]any{"reference": "Practitioner/" + pid})
+	if pid := pv.str(payload, "primaryHealthcarePhysician"); pid != "" {
+		if ref, ok := reference(opts, "Practitioner", pid, pv.str(payload, "primaryHealthcarePhysicianName")); ok {
+			gp = append(gp, ref)
+		}
 	}
-	if cid := str(payload, "primaryHealthcareCenter"); cid != "" {
-		gp = append(gp, map[string]any{"reference": "Organization/" + cid})
+	if cid := pv.str(payload, "primaryHealthcareCenter"); cid != "" {
+		if ref, ok := reference(opts, "Organization", cid, pv.str(payload, "primaryHealthcareCenterName")); ok {
+			gp = append(gp, ref)
+		}
 	}
 	if len(gp) > 0 {
 		patient["generalPractitioner"] = gp
 	}
 	// link
-	if parent := str(payload, "linkedParentUpi"); parent != "" {
-		patient["link"] = []any{map[string]any{
-			"other": map[string]any{"reference": "Patient/" + parent},
-			"type":  "seealso",
-		}}
+	links := make([]any, 0, 1)
+	patientLink := func(upi, linkType string) {
+		if ref, ok := reference(opts, "Patient", upi, ""); ok {
+			links = append(links, map[string]any{"other": ref, "type": linkType})
+		}
 	}
-	// contact (emergency only)
+	if parent := pv.str(payload, "linkedParentUpi"); parent != "" {
+		patientLink(parent, "seealso")
+	}
+	// merge state: a merged-away record is inactive and replaced by the survivor;
+	// the survivor lists the records it replaces.
+	merged, _ := pv.boolv(payload, "isMerged")
+	if into := pv.str(payload, "mergedIntoUpi", "mergedInto"); into != "" {
+		merged = true
+		patientLink(into, "replaced-by")
+	}
+	if merged {
+		patient["active"] = false
+	}
+	for _, child := range pv.strList(payload, "mergedUpis", "mergedFromUpis") {
+		patientLink(child, "replaces")
+	}
+	if len(links) > 0 {
+		patient["link"] = dedupeEntries(links)
+	}
+	// contact: a backend contacts/relatedPersons array, plus the flat emergency contact
 	contacts := make([]any, 0, 1)
+	for _, key := range []string{"contacts", "relatedPersons"} {
+		list, _ := payload[key].([]any)
+		for _, item := range list {
+			if m, ok := item.(map[string]any); ok {
+				if contact := mapContact(m, opts); len(contact) > 0 {
+					contacts = append(contacts, contact)
+				}
+			}
+		}
+	}
 	{
-		nameText := str(payload, "emergencyContactName")
-		first := str(payload, "emergencyContactFirstName", "emergencyContactFirstNameLocal")
-		last := str(payload, "emergencyContactLastName", "emergencyContactLastNameLocal")
+		nameText := pv.str(payload, "emergencyContactName")
+		first := pv.str(payload, "emergencyContactFirstName", "emergencyContactFirstNameLocal")
+		last := pv.str(payload, "emergencyContactLastName", "emergencyContactLastNameLocal")
 		name := map[string]any{}
 		if nameText != "" { name["text"] = nameText }
-		givens := filterNonEmpty(first)
+		givens := pv.filterNonEmpty(first)
 		if len(givens) > 0 { name["given"] = givens }
 		if last != "" { name["family"] = last }
 		telecom := make([]any, 0, 2)
-		if ph := str(payload, "emergencyContactPhoneNumber"); ph != "" {
-			telecom = append(telecom, map[string]any{"system": "phone", "value": ph})
+		if ph := pv.str(payload, "emergencyContactPhoneNumber"); ph != "" {
+			telecom = append(telecom, phoneTelecom(ph, opts))
 		}
-		if em := str(payload, "emergencyContactEmail"); em != "" {
+		if em := pv.str(payload, "emergencyContactEmail"); em != "" {
 			telecom = append(telecom, map[string]any{"system": "email", "value": em})
 		}
-		relText := str(payload, "emergencyContactRelationship")
+		relText := pv.str(payload, "emergencyContactRelationship")
 		contact := map[string]any{}
 		if len(name) > 0 { contact["name"] = name }
-		if relText != "" { contact["relationship"] = []any{map[string]any{"text": relText}} }
+		if relText != "" { contact["relationship"] = []any{codedText(relText, opts.ContactRelationships)} }
 		if len(telecom) > 0 { contact["telecom"] = telecom }
 		if len(contact) > 0 { contacts = append(contacts, contact) }
 	}
-	if len(contacts) > 0 { patient["contact"] = contacts }
+	if len(contacts) > 0 { patient["contact"] = dedupeEntries(contacts) }
 	// photo
 	attachments := make([]any, 0, 1)
-	if u := str(payload, "photoUrl", "avatarUrl", "imageUrl", "pictureUrl"); u != "" {
-		att := map[string]any{"url": u}
-		if ct := str(payload, "photoContentType", "imageContentType", "contentType"); ct != "" {
-			att["contentType"] = ct
-		} else if guessed := guessImageContentType(u); guessed != "" {
-			att["contentType"] = guessed
-		}
-		if title := str(payload, "photoTitle"); title != "" { att["title"] = title }
-		if created := str(payload, "photoCreatedOn", "photoCreation", "createdOn", "modifiedOn"); created != "" {
-			att["creation"] = created
-		}
+	if att, ok := mapPhoto(payload, pathID, opts); ok {
 		attachments = append(attachments, att)
-	} else if b64 := str(payload, "photoBase64", "avatarBase64", "imageBase64", "imageData", "photo"); b64 != "" {
-		att := map[string]any{"data": b64}
-		if ct := str(payload, "photoContentType", "imageContentType", "contentType"); ct != "" {
-			att["contentType"] = ct
+	}
+	if len(attachments) > 0 { patient["photo"] = attachments }
+	// nationality
+	if ext, ok := nationalityExtension(payload, opts); ok {
+		addExtension(patient, ext)
+	}
+	// religion
+	if ext, ok := religionExtension(payload, opts); ok {
+		addExtension(patient, ext)
+	}
+
+	if opts.PreserveUnmapped {
+		if ext := rawExtension(payload, pv); ext != nil {
+			addExtension(patient, ext)
 		}
-		if title := str(payload, "photoTitle"); title != "" { att["title"] = title }
-		if created := str(payload, "photoCreatedOn", "photoCreation", "createdOn", "modifiedOn"); created != "" {
-			att["creation"] = created
+	}
+
+	if sourceHash != "" {
+		addMetaTag(patient, map[string]any{"system": sourceHashTagSystem, "code": sourceHash})
+	}
+	for i, hook := range opts.Hooks {
+		if err := hook(patient, payload); err != nil {
+			return nil, fmt.Errorf("post-transform hook %d: %w", i, err)
 		}
-		attachments = append(attachments, att)
 	}
-	if len(attachments) > 0 { patient["photo"] = attachments }
 
+	pruneEmpty(patient)
 	raw, err := json.Marshal(patient)
 	if err != nil { return nil, err }
 	canonical, err := normalizeViaGoogleFHIR(raw)
@@ -239,22 +285,453 @@ func TransformBackendToFHIRPatient(beJSON []byte, pathID string) ([]byte, error)
 	return canonical, nil
 }
 
+// fhirIDRe is the R4 id datatype pattern.
+var fhirIDRe = regexp.MustCompile(`^[A-Za-z0-9\-.]{1,64}$`)
+
+// reference builds a Reference to resourceType/id, with display when known. The
+// reference is absolute when opts.ReferenceBase is set. ok is false (and a warning
+// logged) when id isn't a valid FHIR id, since the reference would fail validation.
+func reference(opts TransformOptions, resourceType, id, display string) (map[string]any, bool) {
+	if !fhirIDRe.MatchString(id) {
+		log.Printf("Dropping reference to %s: invalid id %q", resourceType, id)
+		countQuality("invalid_reference")
+		return nil, false
+	}
+	target := resourceType + "/" + id
+	if opts.ReferenceBase != "" {
+		target = strings.TrimRight(opts.ReferenceBase, "/") + "/" + target
+	}
+	ref := map[string]any{"reference": target}
+	if display != "" {
+		ref["display"] = display
+	}
+	return ref, true
+}
+
+// mapAddress maps the backend address fields of m onto a FHIR Address (empty when nothing maps).
+func mapAddress(m map[string]any, opts TransformOptions) map[string]any {
+	pv := opts.placeholders()
+	addr := map[string]any{}
+	if text := pv.str(m, "fullAddress", "formattedAddress", "addressText"); text != "" {
+		addr["text"] = text
+	}
+	if t := normalizeAddressType(pv.str(m, "addressType")); t != "" {
+		addr["type"] = t
+	}
+	period := map[string]any{}
+	if from := pv.str(m, "addressValidFrom"); from != "" {
+		period["start"] = normalizeDate(from)
+	}
+	if to := pv.str(m, "addressValidTo"); to != "" {
+		period["end"] = normalizeDate(to)
+	}
+	if len(period) > 0 {
+		addr["period"] = period
+	}
+	lines := pv.filterNonEmpty(pv.str(m, "street"))
+	if len(lines) > 0 {
+		addr["line"] = lines
+	}
+	if city := pv.str(m, "city"); city != "" {
+		addr["city"] = city
+	}
+	if state := pv.str(m, "area"); state != "" {
+		addr["state"] = state
+	}
+	if pc := pv.str(m, "zipCode"); pc != "" {
+		addr["postalCode"] = pc
+	}
+	if country := pv.str(m, "country"); country != "" {
+		if opts.CountryCodes == nil {
+			addr["country"] = strings.ToUpper(country)
+		} else if code, ok := normalizeCountry(country, opts.CountryCodes); ok {
+			addr["country"] = code
+		} else {
+			addr["country"] = code
+			addr["_country"] = map[string]any{"extension": []any{map[string]any{
+				"url":         originalCountryExtensionURL,
+				"valueString": country,
+			}}}
+		}
+	}
+	return addr
+}
+
+// normalizeAddressType maps a backend address type onto postal/physical/both, or "" if unknown.
+func normalizeAddressType(t string) string {
+	switch strings.ToLower(strings.TrimSpace(t)) {
+	case "postal", "mailing", "po box":
+		return "postal"
+	case "physical", "home", "residential", "street", "visiting":
+		return "physical"
+	case "both":
+		return "both"
+	default:
+		return ""
+	}
+}
+
+// mapContact maps one entry of a backend contacts/relatedPersons array onto a
+// Patient.contact (empty when nothing maps).
+func mapContact(m map[string]any, opts TransformOptions) map[string]any {
+	pv := opts.placeholders()
+	contact := map[string]any{}
+	name := map[string]any{}
+	if text := pv.str(m, "name", "fullName"); text != "" {
+		name["text"] = text
+	}
+	if givens := pv.filterNonEmpty(pv.str(m, "firstName", "givenName")); len(givens) > 0 {
+		name["given"] = givens
+	}
+	if last := pv.str(m, "lastName", "familyName"); last != "" {
+		name["family"] = last
+	}
+	if len(name) > 0 {
+		contact["name"] = name
+	}
+	if rel := pv.str(m, "relationship", "relationshipType", "relation", "contactType"); rel != "" {
+		contact["relationship"] = []any{codedText(rel, opts.ContactRelationships)}
+	}
+	telecom := make([]any, 0, 2)
+	if ph := pv.str(m, "phoneNumber", "mobileNumber", "phone"); ph != "" {
+		telecom = append(telecom, phoneTelecom(ph, opts))
+	}
+	if em := pv.str(m, "email"); em != "" {
+		telecom = append(telecom, map[string]any{"system": "email", "value": em})
+	}
+	if len(telecom) > 0 {
+		contact["telecom"] = telecom
+	}
+	if addr := mapAddress(m, opts); len(addr) > 0 {
+		contact["address"] = addr
+	}
+	return contact
+}
+
+// mapName maps one backend name record onto a FHIR HumanName (empty when nothing maps).
+func mapName(m map[string]any, opts TransformOptions) map[string]any {
+	pv := opts.placeholders()
+	first := pv.str(m, "firstName", "givenName")
+	middle := pv.str(m, "middleName", "middle")
+	third := pv.str(m, "thirdName")
+	last := pv.str(m, "lastName", "familyName")
+	full := pv.str(m, "fullName")
+	givens := pv.filterNonEmpty(first, middle, third)
+	if len(givens) == 0 {
+		// Only fall back to the packed field when no discrete part is known, so a
+		// discrete compound name like "Mary Ann" is never split.
+		givens = splitGivenNames(pv.str(m, "givenNames"), pv)
+	}
+	if opts.SplitFullName && last == "" && len(givens) == 0 {
+		// Best effort: last token is the family name, the rest are given names.
+		if tokens := strings.Fields(full); len(tokens) > 1 {
+			last, givens = tokens[len(tokens)-1], tokens[:len(tokens)-1]
+		}
+	}
+	name := map[string]any{}
+	if last != "" {
+		name["family"] = last
+	}
+	if len(givens) > 0 {
+		name["given"] = givens
+	}
+	if full != "" {
+		name["text"] = full
+	}
+	if len(name) == 0 {
+		return name
+	}
+	if use := normalizeNameUse(pv.str(m, "nameUse", "nameType")); use != "" {
+		name["use"] = use
+	}
+	period := map[string]any{}
+	if from := pv.str(m, "nameValidFrom"); from != "" {
+		period["start"] = normalizeDate(from)
+	}
+	if to := pv.str(m, "nameValidTo"); to != "" {
+		period["end"] = normalizeDate(to)
+	}
+	if len(period) > 0 {
+		name["period"] = period
+	}
+	return name
+}
+
+// normalizeNameUse maps a backend name type onto the FHIR name-use code, or "" if unknown.
+func normalizeNameUse(u string) string {
+	switch strings.ToLower(strings.TrimSpace(u)) {
+	case "official", "legal", "primary":
+		return "official"
+	case "usual", "preferred":
+		return "usual"
+	case "nickname", "nick", "alias":
+		return "nickname"
+	case "maiden", "birth":
+		return "maiden"
+	case "old", "previous", "former":
+		return "old"
+	case "temp", "temporary":
+		return "temp"
+	case "anonymous":
+		return "anonymous"
+	default:
+		return ""
+	}
+}
+
+// splitGivenNames splits a packed given-names field. Comma-separated input is split
+// on commas only (keeping "Mary Ann, Jo" as two names); otherwise on whitespace.
+func splitGivenNames(s string, pv placeholders) []string {
+	if strings.Contains(s, ",") {
+		return pv.filterNonEmpty(strings.Split(s, ",")...)
+	}
+	return pv.filterNonEmpty(strings.Fields(s)...)
+}
+
+// dedupeEntries drops repeated elements (e.g. identifiers whose id, patientId and upi
+// all resolved to the same system/value), keeping the first occurrence. Entries are
+// compared by their canonical JSON, so key order doesn't matter.
+func dedupeEntries(list []any) []any {
+	seen := make(map[string]bool, len(list))
+	kept := list[:0]
+	for _, v := range list {
+		b, err := json.Marshal(v)
+		if err != nil || !seen[string(b)] {
+			seen[string(b)] = true
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// fileStatusTagSystem tags Patients whose backend file is closed.
+const fileStatusTagSystem = "urn:empi:file-status"
+
+// isClosedFileStatus reports whether a backend fileStatus denotes a closed file.
+func isClosedFileStatus(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "closed", "close", "archived":
+		return true
+	}
+	return false
+}
+
+// addMetaTag appends tag to resource.meta.tag.
+func addMetaTag(resource map[string]any, tag map[string]any) {
+	meta, _ := resource["meta"].(map[string]any)
+	if meta == nil {
+		meta = map[string]any{}
+		resource["meta"] = meta
+	}
+	tags, _ := meta["tag"].([]any)
+	meta["tag"] = append(tags, tag)
+}
+
+// sourceHashTagSystem tags a Patient with the hash of the backend record it came from.
+const sourceHashTagSystem = "urn:empi:source-hash"
+
+// payloadHash returns the hex SHA-256 of the backend record. json.Marshal sorts map
+// keys, so the hash depends only on the record's content, not the backend's field order.
+func payloadHash(payload map[string]any) string {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// sortBySystemValue orders Identifiers/ContactPoints by system, then value, so the
+// output doesn't depend on which backend fields happened to be present.
+func sortBySystemValue(entries []any) []any {
+	key := func(i int) (string, string) {
+		m, _ := entries[i].(map[string]any)
+		sys, _ := m["system"].(string)
+		val, _ := m["value"].(string)
+		return sys, val
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		si, vi := key(i)
+		sj, vj := key(j)
+		if si != sj {
+			return si < sj
+		}
+		return vi < vj
+	})
+	return entries
+}
+
+// capEntries truncates entries to max (zero means no cap), logging what was dropped
+// so pathological backend records can't balloon the Patient.
+func capEntries(id, element string, entries []any, max int) []any {
+	if max <= 0 || len(entries) <= max {
+		return entries
+	}
+	log.Printf("WARN truncating Patient.%s id=%s: %d entries exceeds limit of %d", element, id, len(entries), max)
+	countQuality("truncated_" + element)
+	return entries[:max]
+}
+
+// addExtension appends ext to the element's extension list.
+func addExtension(elem map[string]any, ext map[string]any) {
+	list, _ := elem["extension"].([]any)
+	elem["extension"] = append(list, ext)
+}
+
+// pruneEmpty recursively removes nil values, blank strings, and empty objects/arrays
+// from m so the output never carries elements like "name":[{}]. It reports whether
+// m itself ended up empty.
+func pruneEmpty(m map[string]any) bool {
+	for k, v := range m {
+		if pruned, ok := pruneValue(v); ok {
+			m[k] = pruned
+		} else {
+			delete(m, k)
+		}
+	}
+	return len(m) == 0
+}
+
+// pruneValue returns v with empty descendants removed; ok is false when nothing is left.
+func pruneValue(v any) (any, bool) {
+	switch t := v.(type) {
+	case nil:
+		return nil, false
+	case string:
+		return t, strings.TrimSpace(t) != ""
+	case map[string]any:
+		return t, !pruneEmpty(t)
+	case []string:
+		kept := placeholders(nil).filterNonEmpty(t...) // blank strings only
+		return kept, len(kept) > 0
+	case []any:
+		kept := t[:0]
+		for _, item := range t {
+			if pruned, ok := pruneValue(item); ok {
+				kept = append(kept, pruned)
+			}
+		}
+		return kept, len(kept) > 0
+	default:
+		return v, true
+	}
+}
+
+// defaultEnvelopeKeys are the backend envelope keys unwrapped when
+// TransformOptions.EnvelopeKeys is nil, in precedence order.
+var defaultEnvelopeKeys = []string{"data", "details"}
+
+// unwrapPayload extracts the backend record from the first envelope key (in keys
+// order) holding an object, a JSON string, or a list of records. Without a usable
+// envelope the document itself is the record.
+func unwrapPayload(beJSON []byte, keys []string) (map[string]any, error) {
+	var anyMap map[string]any
+	if err := json.Unmarshal(beJSON, &anyMap); err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = defaultEnvelopeKeys
+	}
+	for _, key := range keys {
+		d, ok := anyMap[key]
+		if !ok {
+			continue
+		}
+		if v, ok := d.(string); ok {
+			var inner any
+			if err := json.Unmarshal([]byte(v), &inner); err == nil {
+				d = inner
+			}
+		}
+		switch v := d.(type) {
+		case map[string]any:
+			return v, nil
+		case []any:
+			// A list of records: a single read uses the first one.
+			if len(v) == 0 {
+				return nil, fmt.Errorf("backend %s envelope holds no records", key)
+			}
+			m, ok := v[0].(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("backend %s envelope holds %T, want an object", key, v[0])
+			}
+			return m, nil
+		}
+	}
+	return anyMap, nil
+}
+
+// mapPhoto builds the Patient.photo attachment from the backend photo fields.
+// Base64 photos larger than opts.MaxPhotoBytes are replaced by a url attachment
+// pointing at the photo endpoint, or dropped when no PhotoURLBase is configured.
+func mapPhoto(payload map[string]any, id string, opts TransformOptions) (map[string]any, bool) {
+	pv := opts.placeholders()
+	p, err := photoFromFields(payload, pv, photoURLKeys, photoDataKeys, photoContentTypeKeys)
+	if err != nil {
+		log.Printf("Dropping photo id=%s: invalid base64: %v", id, err)
+		countQuality("invalid_photo")
+		return nil, false
+	}
+	if p == nil {
+		return nil, false
+	}
+	var att map[string]any
+	switch {
+	case p.URL != "":
+		att = map[string]any{"url": p.URL}
+	case opts.MaxPhotoBytes > 0 && len(p.Data) > opts.MaxPhotoBytes:
+		if opts.PhotoURLBase == "" {
+			log.Printf("Dropping photo id=%s: %d bytes exceeds limit of %d", id, len(p.Data), opts.MaxPhotoBytes)
+			countQuality("oversized_photo")
+			return nil, false
+		}
+		att = map[string]any{"url": strings.TrimRight(opts.PhotoURLBase, "/") + "/Patient/" + id + "/photo", "size": len(p.Data)}
+	default:
+		att = map[string]any{"data": base64.StdEncoding.EncodeToString(p.Data)}
+	}
+	if p.ContentType != "" {
+		att["contentType"] = p.ContentType
+	}
+	if title := pv.str(payload, "photoTitle"); title != "" {
+		att["title"] = title
+	}
+	if created := pv.str(payload, "photoCreatedOn", "photoCreation", "createdOn", "modifiedOn"); created != "" {
+		att["creation"] = created
+	}
+	return att, true
+}
+
+// decodePhoto decodes a backend base64 photo, tolerating a data: URI prefix and unpadded input.
+func decodePhoto(b64 string) ([]byte, error) {
+	if strings.HasPrefix(b64, "data:") {
+		if i := strings.Index(b64, ","); i >= 0 {
+			b64 = b64[i+1:]
+		}
+	}
+	b64 = strings.TrimSpace(b64)
+	if raw, err := base64.StdEncoding.DecodeString(b64); err == nil {
+		return raw, nil
+	}
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(b64, "="))
+}
+
 // normalizeViaGoogleFHIR validates the generated Patient JSON via google/fhir (R4)
 // by unmarshalling to the typed model. If valid, it returns the input unchanged.
 func normalizeViaGoogleFHIR(patientJSON []byte) ([]byte, error) {
-	um, err := jsonformat.NewUnmarshaller("UTC", fhirversion.R4)
-	if err != nil { return nil, err }
-	if _, err := um.Unmarshal(patientJSON); err != nil { return nil, err }
+	if _, err := unmarshalR4(patientJSON); err != nil {
+		countValidationFailure(err)
+		return nil, err
+	}
 	return patientJSON, nil
 }
 
 // Helpers
-func str(m map[string]any, keys ...string) string {
+func (pv placeholders) str(m map[string]any, keys ...string) string {
 	for _, k := range keys {
 		if v, ok := m[k]; ok {
 			switch t := v.(type) {
 			case string:
-				if strings.TrimSpace(t) != "" && t != "-" && t != "null" { return t }
+				if !pv.is(t) { return t }
 			case float64:
 				return strconv.FormatInt(int64(t), 10)
 			case json.Number:
@@ -265,13 +742,40 @@ func str(m map[string]any, keys ...string) string {
 	return ""
 }
 
-func boolv(m map[string]any, keys ...string) (bool, bool) {
+// strList reads the first present key as a list of strings, accepting either a JSON
+// array or a comma-separated string.
+func (pv placeholders) strList(m map[string]any, keys ...string) []string {
+	for _, k := range keys {
+		switch t := m[k].(type) {
+		case []any:
+			vals := make([]string, 0, len(t))
+			for i := range t {
+				if s := pv.str(map[string]any{k: t[i]}, k); s != "" {
+					vals = append(vals, s)
+				}
+			}
+			if len(vals) > 0 {
+				return vals
+			}
+		case string:
+			if vals := pv.filterNonEmpty(strings.Split(t, ",")...); len(vals) > 0 {
+				return vals
+			}
+		}
+	}
+	return nil
+}
+
+func (pv placeholders) boolv(m map[string]any, keys ...string) (bool, bool) {
 	for _, k := range keys {
 		if v, ok := m[k]; ok {
 			switch t := v.(type) {
 			case bool:
 				return t, true
 			case string:
+				if pv.is(t) {
+					continue
+				}
 				lower := strings.ToLower(strings.TrimSpace(t))
 				if lower == "true" || lower == "1" || lower == "yes" { return true, true }
 				if lower == "false" || lower == "0" || lower == "no" { return false, true }
@@ -283,15 +787,74 @@ func boolv(m map[string]any, keys ...string) (bool, bool) {
 	return false, false
 }
 
-func filterNonEmpty(vals ...string) []string {
+// placeholders are backend values meaning "no value", besides blank strings
+// (compared case-insensitively). The helpers reading backend fields skip them.
+type placeholders []string
+
+// defaultPlaceholders are used when TransformOptions.Placeholders is nil.
+var defaultPlaceholders = placeholders{"-", "null"}
+
+// placeholders returns the configured placeholder set, else defaultPlaceholders.
+func (opts TransformOptions) placeholders() placeholders {
+	if opts.Placeholders == nil {
+		return defaultPlaceholders
+	}
+	return opts.Placeholders
+}
+
+// is reports whether v is blank or one of pv.
+func (pv placeholders) is(v string) bool {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return true
+	}
+	for _, p := range pv {
+		if strings.EqualFold(v, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pv placeholders) filterNonEmpty(vals ...string) []string {
 	res := make([]string, 0, len(vals))
 	for _, v := range vals {
-		v = strings.TrimSpace(v)
-		if v != "" && v != "-" && v != "null" { res = append(res, v) }
+		if !pv.is(v) { res = append(res, strings.TrimSpace(v)) }
 	}
 	return res
 }
 
+// genderTextExtensionURL keeps the backend gender_text when the coded gender wins.
+const genderTextExtensionURL = "urn:empi:extension:gender-text"
+
+// mapGender sets Patient.gender from the backend gender_text/gender fields according
+// to precedence.
+func mapGender(patient, payload map[string]any, precedence GenderPrecedence, pv placeholders) {
+	gtxt := pv.str(payload, "gender_text")
+	code := pv.str(payload, "gender")
+	first, second := gtxt, code
+	if precedence != GenderTextFirst {
+		first, second = code, gtxt
+	}
+	raw := first
+	if raw == "" {
+		raw = second
+	}
+	if raw == "" {
+		return
+	}
+	patient["gender"] = normalizeGender(raw)
+	if patient["gender"] == "unknown" && !isUnknownGender(raw) {
+		countQuality("unmapped_gender")
+	}
+	if precedence == GenderCodeWithText && code != "" && gtxt != "" {
+		patient["_gender"] = map[string]any{"extension": []any{map[string]any{
+			"url":         genderTextExtensionURL,
+			"valueString": gtxt,
+		}}}
+	}
+}
+
 func normalizeGender(g string) string {
 	g = strings.ToLower(strings.TrimSpace(g))
 	switch g {
@@ -310,6 +873,16 @@ func normalizeGender(g string) string {
 	}
 }
 
+// isUnknownGender reports whether the backend explicitly sent an unknown gender, as
+// opposed to a value normalizeGender couldn't map.
+func isUnknownGender(g string) bool {
+	switch strings.ToLower(strings.TrimSpace(g)) {
+	case "unknown", "u", "0":
+		return true
+	}
+	return false
+}
+
 func normalizeDate(s string) string {
 	s = strings.TrimSpace(s)
 	if i := strings.IndexAny(s, "T "); i > 0 { s = s[:i] }
@@ -317,6 +890,59 @@ func normalizeDate(s string) string {
 	return s
 }
 
+// birthTimeExtensionURL is the core extension carrying the time of birth on Patient.birthDate.
+const birthTimeExtensionURL = "http://hl7.org/fhir/StructureDefinition/patient-birthTime"
+
+var (
+	partialDateRe = regexp.MustCompile(`^\d{4}(-\d{2}(-\d{2})?)?$`)
+	zonedTimeRe   = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:\d{2})$`)
+)
+
+// normalizeBirthDate returns the FHIR date for a backend birth date, keeping year or
+// year-month precision when that is all the backend knows. birthTime is set only for
+// timestamps carrying a real (non-midnight) time and a zone, since FHIR requires one.
+// An unparseable value yields "".
+func normalizeBirthDate(s string) (date, birthTime string) {
+	s = strings.TrimSpace(s)
+	date = s
+	if i := strings.IndexAny(s, "T "); i > 0 {
+		date = s[:i]
+		ts := strings.Replace(s, " ", "T", 1)
+		if zonedTimeRe.MatchString(ts) && validBirthTime(ts) {
+			// The zone guarantees a character after HH:MM.
+			clock := ts[i+1:]
+			midnight := strings.HasPrefix(clock, "00:00:00") || (strings.HasPrefix(clock, "00:00") && clock[5] != ':')
+			if !midnight {
+				birthTime = ts
+			}
+		}
+	}
+	if !partialDateRe.MatchString(date) || !validPartialDate(date) {
+		return "", ""
+	}
+	return date, birthTime
+}
+
+// partialDateLayouts are the time layouts of a FHIR date, by length.
+var partialDateLayouts = map[int]string{4: "2006", 7: "2006-01", 10: "2006-01-02"}
+
+// validPartialDate reports whether a date already shaped like partialDateRe names a
+// real calendar date (no month 13 or February 30).
+func validPartialDate(date string) bool {
+	_, err := time.Parse(partialDateLayouts[len(date)], date)
+	return err == nil
+}
+
+// validBirthTime reports whether a timestamp shaped like zonedTimeRe is a real time.
+func validBirthTime(ts string) bool {
+	layout := time.RFC3339
+	if ts[16] != ':' { // the zone follows HH:MM directly
+		layout = "2006-01-02T15:04Z07:00"
+	}
+	_, err := time.Parse(layout, ts)
+	return err == nil
+}
+
 func guessImageContentType(u string) string {
 	lower := strings.ToLower(u)
 	switch {
@@ -333,6 +959,16 @@ func guessImageContentType(u string) string {
 	}
 }
 
+// sniffImageContentType detects the image type from decoded photo bytes.
+// Non-image content yields "" so we never label a photo with a misleading type.
+func sniffImageContentType(b []byte) string {
+	ct := http.DetectContentType(b)
+	if strings.HasPrefix(ct, "image/") {
+		return ct
+	}
+	return ""
+}
+
 // HTTPTransport returns an HTTP client transport configured for insecure TLS (curl -k) when needed.
 // Provided here in case callers in other packages want a ready-to-use transport.
 func HTTPTransport(insecure bool) *http.Transport {
@@ -0,0 +1,559 @@
+package fhir
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// transform runs the transform with opts and decodes the resulting Patient.
+func transform(t *testing.T, be string, opts TransformOptions) map[string]any {
+	t.Helper()
+	out, err := TransformBackendToFHIRPatientWithOptions([]byte(be), "123", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p map[string]any
+	if err := json.Unmarshal(out, &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// compact marshals v for comparisons against expected JSON.
+func compact(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestTransformPlaceholders(t *testing.T) {
+	be := `{"data":{"upi":"123","givenNames":"John,N/A","lastName":"n/a","email":"-","religion":"None","isDeceased":"N/A"}}`
+	tests := []struct {
+		name         string
+		placeholders []string
+		want         string
+	}{
+		{"defaults keep N/A", nil, `[{"family":"n/a","given":["John","N/A"]}]`},
+		{"configured N/A is empty", []string{"-", "null", "N/A"}, `[{"given":["John"]}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultTransformOptions()
+			opts.Placeholders = tt.placeholders
+			p := transform(t, be, opts)
+			if got := compact(t, p["name"]); got != tt.want {
+				t.Errorf("name = %s, want %s", got, tt.want)
+			}
+			if _, ok := p["telecom"]; ok {
+				t.Errorf("placeholder email emitted: %v", p["telecom"])
+			}
+			if _, ok := p["deceasedBoolean"]; ok {
+				t.Errorf("placeholder isDeceased emitted: %v", p["deceasedBoolean"])
+			}
+			if _, ok := p["extension"]; !ok {
+				t.Errorf(`religion "None" dropped`)
+			}
+		})
+	}
+}
+
+func TestTransformConfiguredPlaceholdersReplaceDefaults(t *testing.T) {
+	opts := DefaultTransformOptions()
+	opts.Placeholders = []string{"N/A"}
+	p := transform(t, `{"data":{"upi":"123","firstName":"John","lastName":"-","mergedUpis":["N/A","456"]}}`, opts)
+	if got := compact(t, p["name"]); got != `[{"family":"-","given":["John"]}]` {
+		t.Errorf("name = %s", got)
+	}
+	if got := compact(t, p["link"]); got != `[{"other":{"reference":"Patient/456"},"type":"replaces"}]` {
+		t.Errorf("link = %s", got)
+	}
+}
+
+// fhirPatient is a genuine R4 Patient, deliberately not in canonical key order.
+const fhirPatient = `{"resourceType":"Patient","id":"123","name":[{"given":["John"],"family":"Doe"}],"gender":"male"}`
+
+func TestDefaultPlaceholdersIgnoreCase(t *testing.T) {
+	p := transform(t, `{"data":{"upi":"123","firstName":"John","lastName":"NULL","email":"Null"}}`, DefaultTransformOptions())
+	if got := compact(t, p["name"]); got != `[{"given":["John"]}]` {
+		t.Errorf("name = %s, want NULL treated as empty", got)
+	}
+	if _, ok := p["telecom"]; ok {
+		t.Errorf("Null email emitted: %v", p["telecom"])
+	}
+}
+
+func TestBoolvPlaceholders(t *testing.T) {
+	payload := map[string]any{"isDeceased": "0", "fallback": "yes"}
+	if v, ok := defaultPlaceholders.boolv(payload, "isDeceased"); !ok || v {
+		t.Errorf("default boolv = %v, %v; want false, true", v, ok)
+	}
+	// A configured placeholder is no value, so the next key is consulted.
+	pv := placeholders{"0"}
+	if v, ok := pv.boolv(payload, "isDeceased", "fallback"); !ok || !v {
+		t.Errorf("boolv = %v, %v; want true, true from the fallback key", v, ok)
+	}
+	if _, ok := pv.boolv(payload, "isDeceased"); ok {
+		t.Error("placeholder read as a boolean")
+	}
+}
+
+func TestTransformPassthrough(t *testing.T) {
+	t.Run("FHIR Patient is returned byte-identical", func(t *testing.T) {
+		out, err := TransformBackendToFHIRPatient([]byte(fhirPatient), "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != fhirPatient {
+			t.Errorf("passthrough changed the Patient:\n%s\nwant\n%s", out, fhirPatient)
+		}
+	})
+	t.Run("enveloped FHIR Patient passes through unwrapped", func(t *testing.T) {
+		out, err := TransformBackendToFHIRPatient([]byte(`{"details":`+fhirPatient+`}`), "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, want any
+		if err := json.Unmarshal(out, &got); err != nil {
+			t.Fatal(err)
+		}
+		_ = json.Unmarshal([]byte(fhirPatient), &want)
+		if compact(t, got) != compact(t, want) {
+			t.Errorf("inner Patient not passed through: %s", out)
+		}
+	})
+	t.Run("other FHIR resource is transformed, not passed through", func(t *testing.T) {
+		obs := `{"resourceType":"Observation","id":"o1","status":"final","code":{"text":"x"}}`
+		p := transform(t, obs, DefaultTransformOptions())
+		if p["resourceType"] != "Patient" || p["id"] != "123" {
+			t.Errorf("Observation passed through: %v", p)
+		}
+	})
+}
+
+func TestLooksLikePatient(t *testing.T) {
+	for in, want := range map[string]bool{
+		fhirPatient: true,
+		`{"resourceType":"Observation","id":"o1","status":"final","code":{"text":"x"}}`: false,
+		`{"resourceType":"Patient","gender":"robot"}`:                                   false,
+		`{"upi":"123","firstName":"John"}`:                                              false,
+		`not json`:                                                                      false,
+	} {
+		if got := LooksLikePatient([]byte(in)); got != want {
+			t.Errorf("LooksLikePatient(%s) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestTransformOversizedPhotoURL(t *testing.T) {
+	be := `{"data":{"upi":"123","photoBase64":"` + strings.Repeat("A", 64) + `"}}`
+	for base, want := range map[string]string{
+		"/fhir":   "/fhir/Patient/123/photo",
+		"/":       "/Patient/123/photo",
+		"":        "",
+		"/emr/x/": "/emr/x/Patient/123/photo",
+	} {
+		opts := DefaultTransformOptions()
+		opts.MaxPhotoBytes = 8
+		opts.PhotoURLBase = base
+		p := transform(t, be, opts)
+		got := ""
+		if photos, ok := p["photo"].([]any); ok {
+			got, _ = photos[0].(map[string]any)["url"].(string)
+		}
+		if got != want {
+			t.Errorf("PhotoURLBase %q: photo url = %q, want %q", base, got, want)
+		}
+	}
+}
+
+func TestTransformMinimalPatient(t *testing.T) {
+	for _, be := range []string{`{"data":{"upi":"123"}}`, `{"data":{}}`, `{"data":{"firstName":"-","street":"","names":[{}],"contacts":[{"name":"null"}]}}`} {
+		out, err := TransformBackendToFHIRPatient([]byte(be), "123")
+		if err != nil {
+			t.Fatalf("%s: %v", be, err)
+		}
+		if err := ValidatePatientR4(out); err != nil {
+			t.Errorf("%s: invalid Patient %s: %v", be, out, err)
+		}
+		var p map[string]any
+		_ = json.Unmarshal(out, &p)
+		for k := range p {
+			if k != "resourceType" && k != "id" && k != "identifier" {
+				t.Errorf("%s: unexpected element %s in %s", be, k, out)
+			}
+		}
+	}
+}
+
+func TestPruneEmpty(t *testing.T) {
+	m := map[string]any{
+		"name":    []any{map[string]any{}},
+		"address": []any{map[string]any{"line": []string{" ", ""}, "city": ""}},
+		"telecom": []any{nil, map[string]any{"value": "1"}},
+		"gender":  "male",
+		"_x":      map[string]any{"extension": []any{}},
+	}
+	pruneEmpty(m)
+	if got := compact(t, m); got != `{"gender":"male","telecom":[{"value":"1"}]}` {
+		t.Errorf("pruneEmpty = %s", got)
+	}
+}
+
+func TestNormalizeBirthDate(t *testing.T) {
+	tests := []struct {
+		in, date, birthTime string
+	}{
+		{"1980", "1980", ""},
+		{"1980-07", "1980-07", ""},
+		{"1980-07-15", "1980-07-15", ""},
+		{"1980-07-15T00:00:00", "1980-07-15", ""},
+		{"1980-07-15T00:00:00Z", "1980-07-15", ""},
+		{"1980-07-15T08:30:00+03:00", "1980-07-15", "1980-07-15T08:30:00+03:00"},
+		{"1980-07-15 08:30Z", "1980-07-15", "1980-07-15T08:30Z"},
+		{"1980-07-15T08:30:00", "1980-07-15", ""}, // no zone: FHIR dateTime with time needs one
+		{"15/07/1980", "", ""},
+		{"198", "", ""},
+		{"1980-13", "", ""},
+		{"1980-00", "", ""},
+		{"1980-13-45", "", ""},
+		{"1980-02-30", "", ""},
+		{"1980-07-15T25:00:00Z", "1980-07-15", ""}, // a bad time keeps the date
+		{"1980-07-15 08:61Z", "1980-07-15", ""},
+	}
+	for _, tt := range tests {
+		date, birthTime := normalizeBirthDate(tt.in)
+		if date != tt.date || birthTime != tt.birthTime {
+			t.Errorf("normalizeBirthDate(%q) = %q, %q; want %q, %q", tt.in, date, birthTime, tt.date, tt.birthTime)
+		}
+	}
+}
+
+func TestTransformPartialBirthDate(t *testing.T) {
+	for dob, want := range map[string]any{"1980": "1980", "1980-07": "1980-07", "1980-13": nil, "1980-13-45": nil} {
+		p := transform(t, `{"data":{"upi":"123","dateOfBirth":"`+dob+`"}}`, DefaultTransformOptions())
+		if p["birthDate"] != want {
+			t.Errorf("dateOfBirth %q: birthDate = %v, want %v", dob, p["birthDate"], want)
+		}
+	}
+	p := transform(t, `{"data":{"upi":"123","dateOfBirth":"1980-07-15T08:30:00Z"}}`, DefaultTransformOptions())
+	if got := compact(t, p["_birthDate"]); got != `{"extension":[{"url":"`+birthTimeExtensionURL+`","valueDateTime":"1980-07-15T08:30:00Z"}]}` {
+		t.Errorf("_birthDate = %s", got)
+	}
+}
+
+func TestTransformDedupesIdentifiers(t *testing.T) {
+	be := `{"data":{"id":"123","patientId":"123","upi":"123","legacyMRN":"M1","medicalRecordNumber":"M1",` +
+		`"mobileNumber":"0501234567","phoneNumber":"0501234567"}}`
+	p := transform(t, be, DefaultTransformOptions())
+	ids, _ := p["identifier"].([]any)
+	seen := map[string]bool{}
+	for _, item := range ids {
+		id := item.(map[string]any)
+		key := id["system"].(string) + "|" + id["value"].(string)
+		if seen[key] {
+			t.Errorf("duplicate identifier %s", key)
+		}
+		seen[key] = true
+	}
+	if !seen["urn:upi|123"] || !seen["urn:mrn|M1"] || len(ids) != 2 {
+		t.Errorf("identifier = %s, want one urn:mrn and one urn:upi", compact(t, ids))
+	}
+	if telecom, _ := p["telecom"].([]any); len(telecom) != 1 {
+		t.Errorf("telecom = %s, want one entry", compact(t, telecom))
+	}
+}
+
+func TestDedupeEntries(t *testing.T) {
+	in := []any{
+		map[string]any{"system": "urn:upi", "value": "1", "use": "official"},
+		map[string]any{"use": "official", "value": "1", "system": "urn:upi"},
+		map[string]any{"system": "urn:upi", "value": "2"},
+	}
+	if got := compact(t, dedupeEntries(in)); got != `[{"system":"urn:upi","use":"official","value":"1"},{"system":"urn:upi","value":"2"}]` {
+		t.Errorf("dedupeEntries = %s", got)
+	}
+}
+
+func TestTransformGenderPrecedence(t *testing.T) {
+	be := `{"data":{"upi":"123","gender":"F","gender_text":"Male"}}`
+	tests := []struct {
+		precedence GenderPrecedence
+		gender     string
+		text       string
+	}{
+		{GenderTextFirst, "male", ""},
+		{GenderCodeFirst, "female", ""},
+		{GenderCodeWithText, "female", "Male"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.precedence), func(t *testing.T) {
+			opts := DefaultTransformOptions()
+			opts.GenderPrecedence = tt.precedence
+			p := transform(t, be, opts)
+			if p["gender"] != tt.gender {
+				t.Errorf("gender = %v, want %s", p["gender"], tt.gender)
+			}
+			text := ""
+			if ext, ok := p["_gender"].(map[string]any); ok {
+				e := ext["extension"].([]any)[0].(map[string]any)
+				if e["url"] == genderTextExtensionURL {
+					text, _ = e["valueString"].(string)
+				}
+			}
+			if text != tt.text {
+				t.Errorf("gender text extension = %q, want %q", text, tt.text)
+			}
+		})
+	}
+	opts := DefaultTransformOptions()
+	opts.GenderPrecedence = GenderCodeFirst
+	if p := transform(t, `{"data":{"upi":"123","gender_text":"Female"}}`, opts); p["gender"] != "female" {
+		t.Errorf("code-first without a code: gender = %v, want the text fallback", p["gender"])
+	}
+}
+
+func TestTransformManagingOrganizationFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		be   string
+		want string
+	}{
+		{"registeredAt preferred", `{"data":{"upi":"123","registeredAt":"59","registeredAtName":"North","hospitalId":"60"}}`,
+			`{"display":"North","reference":"Organization/59"}`},
+		{"invalid registeredAt falls back", `{"data":{"upi":"123","registeredAt":"59 North","hospitalId":"60","hospitalName":"South"}}`,
+			`{"display":"South","reference":"Organization/60"}`},
+		{"both invalid", `{"data":{"upi":"123","registeredAt":"59 North","hospitalId":"60/61"}}`, `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := transform(t, tt.be, DefaultTransformOptions())
+			if got := compact(t, p["managingOrganization"]); got != tt.want {
+				t.Errorf("managingOrganization = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformOutputStable(t *testing.T) {
+	first, err := TransformBackendToFHIRPatient(backendRecord, "1000234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		out, err := TransformBackendToFHIRPatient(backendRecord, "1000234")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != string(first) {
+			t.Fatalf("run %d differs:\n%s\nvs\n%s", i, out, first)
+		}
+	}
+	var p struct {
+		Identifier, Telecom []struct{ System, Value string }
+	}
+	if err := json.Unmarshal(first, &p); err != nil {
+		t.Fatal(err)
+	}
+	for name, entries := range map[string][]struct{ System, Value string }{"identifier": p.Identifier, "telecom": p.Telecom} {
+		if len(entries) < 2 {
+			t.Fatalf("%s has %d entries, want several", name, len(entries))
+		}
+		for i := 1; i < len(entries); i++ {
+			a, b := entries[i-1], entries[i]
+			if a.System > b.System || (a.System == b.System && a.Value > b.Value) {
+				t.Errorf("%s not sorted by system then value: %+v before %+v", name, a, b)
+			}
+		}
+	}
+}
+
+func TestSortBySystemValue(t *testing.T) {
+	entry := func(system, value string) any { return map[string]any{"system": system, "value": value} }
+	got := sortBySystemValue([]any{entry("phone", "2"), entry("email", "b"), entry("phone", "1"), entry("email", "a")})
+	want := `[{"system":"email","value":"a"},{"system":"email","value":"b"},` +
+		`{"system":"phone","value":"1"},{"system":"phone","value":"2"}]`
+	if s := compact(t, got); s != want {
+		t.Errorf("sorted = %s, want %s", s, want)
+	}
+}
+
+func TestTransformEnvelopeKeys(t *testing.T) {
+	const record = `{"upi":"123","firstName":"John","lastName":"Doe"}`
+	custom := []string{"data", "details", "result", "patient"}
+	tests := []struct {
+		name string
+		be   string
+		keys []string
+		want string
+	}{
+		{"data by default", `{"data":` + record + `}`, nil, `[{"family":"Doe","given":["John"]}]`},
+		{"result with custom keys", `{"status":"ok","result":` + record + `}`, custom, `[{"family":"Doe","given":["John"]}]`},
+		{"patient with custom keys", `{"patient":` + record + `}`, custom, `[{"family":"Doe","given":["John"]}]`},
+		{"patient list", `{"patient":[` + record + `]}`, custom, `[{"family":"Doe","given":["John"]}]`},
+		{"stringified result", `{"result":` + strconv.Quote(record) + `}`, custom, `[{"family":"Doe","given":["John"]}]`},
+		{"stringified data list", `{"data":` + strconv.Quote(`[`+record+`]`) + `}`, nil, `[{"family":"Doe","given":["John"]}]`},
+		{"precedence", `{"patient":{"firstName":"Other"},"result":` + record + `}`, custom, `[{"family":"Doe","given":["John"]}]`},
+		{"result not unwrapped by default", `{"result":` + record + `}`, nil, `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultTransformOptions()
+			opts.EnvelopeKeys = tt.keys
+			p := transform(t, tt.be, opts)
+			if got := compact(t, p["name"]); got != tt.want {
+				t.Errorf("name = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformEmptyEnvelope(t *testing.T) {
+	opts := DefaultTransformOptions()
+	opts.EnvelopeKeys = []string{"data", "result"}
+	for _, be := range []string{`{"result":[]}`, `{"data":"[]"}`} {
+		_, err := TransformBackendToFHIRPatientWithOptions([]byte(be), "123", opts)
+		if err == nil || !strings.Contains(err.Error(), "envelope holds no records") {
+			t.Errorf("%s: err = %v, want the empty envelope error", be, err)
+		}
+	}
+}
+
+func TestTransformEmergencyContactRelationship(t *testing.T) {
+	tests := []struct {
+		name string
+		rel  string
+		want string
+	}{
+		{"mapped", "Next of Kin", `[{"coding":[{"code":"N","display":"Next-of-Kin","system":"http://terminology.hl7.org/CodeSystem/v2-0131"}],"text":"Next of Kin"}]`},
+		{"unmapped", "Colleague", `[{"text":"Colleague"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			be := `{"data":{"upi":"123","emergencyContactName":"Sara","emergencyContactRelationship":"` + tt.rel + `"}}`
+			out, err := TransformBackendToFHIRPatient([]byte(be), "123")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ValidatePatientR4(out); err != nil {
+				t.Fatalf("invalid Patient: %v", err)
+			}
+			var p struct {
+				Contact []struct{ Relationship json.RawMessage }
+			}
+			if err := json.Unmarshal(out, &p); err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Contact) != 1 {
+				t.Fatalf("contact = %+v, want 1", p.Contact)
+			}
+			if got := string(p.Contact[0].Relationship); got != tt.want {
+				t.Errorf("relationship = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformCodedText(t *testing.T) {
+	be := `{"data":{"upi":"123","religion":"Muslim","contacts":[` +
+		`{"name":"Sara","relationship":"Spouse"},{"name":"Omar","relationship":"Colleague"}]}}`
+	out, err := TransformBackendToFHIRPatient([]byte(be), "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidatePatientR4(out); err != nil {
+		t.Fatalf("invalid Patient: %v", err)
+	}
+	var p struct {
+		Contact []struct {
+			Relationship []json.RawMessage
+		}
+		Extension []struct {
+			URL                  string
+			ValueCodeableConcept json.RawMessage
+		}
+	}
+	if err := json.Unmarshal(out, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Contact) != 2 {
+		t.Fatalf("contact = %+v, want 2", p.Contact)
+	}
+	want := map[string]string{
+		"mapped":   `{"coding":[{"code":"SPS","display":"spouse","system":"http://terminology.hl7.org/CodeSystem/v3-RoleCode"}],"text":"Spouse"}`,
+		"unmapped": `{"text":"Colleague"}`,
+		"religion": `{"coding":[{"code":"1023","display":"Islam","system":"http://terminology.hl7.org/CodeSystem/v3-ReligiousAffiliation"}],"text":"Muslim"}`,
+	}
+	got := map[string]string{
+		"mapped":   string(p.Contact[0].Relationship[0]),
+		"unmapped": string(p.Contact[1].Relationship[0]),
+	}
+	for _, ext := range p.Extension {
+		if ext.URL == religionExtensionURL {
+			got["religion"] = string(ext.ValueCodeableConcept)
+		}
+	}
+	for name, w := range want {
+		if got[name] != w {
+			t.Errorf("%s = %s, want %s", name, got[name], w)
+		}
+	}
+}
+
+func TestTransformMergeLinks(t *testing.T) {
+	type reference struct{ Reference string }
+	type link struct {
+		Type  string
+		Other reference
+	}
+	tests := []struct {
+		name   string
+		be     string
+		active bool
+		links  []link
+	}{
+		{"merged source", `{"data":{"upi":"1","fileStatus":"Active","isMerged":true,"mergedIntoUpi":"2"}}`, false,
+			[]link{{"replaced-by", reference{"Patient/2"}}}},
+		{"survivor", `{"data":{"upi":"2","fileStatus":"Active","mergedUpis":["1","3"]}}`, true,
+			[]link{{"replaces", reference{"Patient/1"}}, {"replaces", reference{"Patient/3"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := TransformBackendToFHIRPatient([]byte(tt.be), "123")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var p struct {
+				Active *bool
+				Link   []link
+			}
+			if err := json.Unmarshal(out, &p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Active == nil || *p.Active != tt.active {
+				t.Errorf("active = %v, want %v", p.Active, tt.active)
+			}
+			if got, want := compact(t, p.Link), compact(t, tt.links); got != want {
+				t.Errorf("link = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestTransformAddresses(t *testing.T) {
+	be := `{"data":{"upi":"123","city":"Riyadh","addresses":[{"city":"Jeddah","addressType":"postal"},{"city":"Riyadh"},{"city":"Dammam"}]}}`
+	p := transform(t, be, DefaultTransformOptions())
+	if got, want := compact(t, p["address"]), `[{"city":"Jeddah","type":"postal"},{"city":"Riyadh"},{"city":"Dammam"}]`; got != want {
+		t.Errorf("address = %s, want %s (the flat Riyadh deduped)", got, want)
+	}
+	opts := DefaultTransformOptions()
+	opts.MaxAddresses = 2
+	p = transform(t, be, opts)
+	if got, want := compact(t, p["address"]), `[{"city":"Jeddah","type":"postal"},{"city":"Riyadh"}]`; got != want {
+		t.Errorf("capped address = %s, want %s", got, want)
+	}
+}
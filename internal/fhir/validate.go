@@ -1,33 +1,77 @@
 package fhir
 
 import (
+	"encoding/json"
+	"fmt"
+
 	fhirversion "github.com/google/fhir/go/fhirversion"
 	jsonformat "github.com/google/fhir/go/jsonformat"
+	r4pb "github.com/google/fhir/go/proto/google/fhir/proto/r4/core/resources/bundle_and_contained_resource_go_proto"
 )
 
 // ValidatePatientR4 attempts to unmarshal+validate the input as an R4 Patient using jsonformat.
 // It returns nil if validation passes; an error otherwise.
 func ValidatePatientR4(data []byte) error {
-	um, err := jsonformat.NewUnmarshaller("UTC", fhirversion.R4)
+	cr, err := unmarshalR4(data)
 	if err != nil {
 		return err
 	}
-	_, err = um.Unmarshal(data)
-	return err
+	if cr.GetPatient() == nil {
+		return fmt.Errorf("resource is not a Patient")
+	}
+	return nil
 }
 
-// LooksLikePatient does a minimal check via jsonformat by attempting to unmarshal as Patient.
-// Callers typically use Transform or cheap JSON checks; prefer Transform's own detection if available.
-func LooksLikePatient(data []byte) bool {
-	// We avoid importing encoding/json here to keep this package focused on FHIR tooling.
-	// Instead reuse jsonformat's unmarshaller in a permissive way. If it can parse as a Patient,
-	// we consider it to look like a Patient. This is slightly stronger than string checking.
-	um, err := jsonformat.NewUnmarshaller("UTC", fhirversion.R4)
+// ValidateBundleR4 attempts to unmarshal+validate the input as an R4 Bundle, entries
+// included. It returns nil if validation passes; an error otherwise.
+func ValidateBundleR4(data []byte) error {
+	cr, err := unmarshalR4(data)
 	if err != nil {
+		return err
+	}
+	if cr.GetBundle() == nil {
+		return fmt.Errorf("resource is not a Bundle")
+	}
+	return nil
+}
+
+// LooksLikePatient reports whether data is an R4 Patient. A cheap decode of just
+// resourceType rejects everything else before the full google/fhir unmarshal, which
+// then confirms the Patient is structurally valid.
+func LooksLikePatient(data []byte) bool {
+	var probe struct {
+		ResourceType string `json:"resourceType"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil || probe.ResourceType != "Patient" {
 		return false
 	}
-	if _, err := um.Unmarshal(data); err != nil {
+	cr, err := unmarshalR4(data)
+	if err != nil {
 		return false
 	}
-	return true
+	return cr.GetPatient() != nil
+}
+
+// unmarshalR4 parses data as any R4 resource. The unmarshaller accepts every resource
+// type, so callers must check which one they got. A panic inside the library is
+// returned as an error, so one malformed record can't take the process down.
+func unmarshalR4(data []byte) (cr *r4pb.ContainedResource, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			cr, err = nil, fmt.Errorf("google/fhir unmarshal panicked: %v", rec)
+		}
+	}()
+	um, err := jsonformat.NewUnmarshaller("UTC", fhirversion.R4)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := um.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	cr, ok := msg.(*r4pb.ContainedResource)
+	if !ok {
+		return nil, fmt.Errorf("unexpected R4 message type %T", msg)
+	}
+	return cr, nil
 }
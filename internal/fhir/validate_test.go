@@ -0,0 +1,124 @@
+package fhir
+
+import (
+	"strings"
+	"testing"
+)
+
+// backendRecord is a realistic EMPI read response, which the transform checks with
+// LooksLikePatient before mapping it.
+var backendRecord = []byte(`{"data":{"upi":"1000234","legacyMRN":"MRN-88231","firstName":"Mohammed","middleName":"Abdullah",` +
+	`"thirdName":"Saleh","lastName":"Al-Qahtani","gender":"male","gender_text":"Male","dateOfBirth":"1980-07-15T00:00:00",` +
+	`"mobileNumber":"0501234567","email":"m.q@example.com","idType":"national","idNumber":"1010101010",` +
+	`"nationality":"Saudi Arabia","nationalityCode":"SAU","religion":"Islam","language":"Arabic","maritialStatus":"2",` +
+	`"city":"Riyadh","area":"Riyadh","street":"King Fahd Rd","zipCode":"12271","country":"SAU","fileStatus":"Active",` +
+	`"registeredAt":"59","registeredAtName":"Main Hospital","emergencyContactName":"Sara","emergencyContactPhoneNumber":"0559876543",` +
+	`"emergencyContactRelationship":"Spouse"}}`)
+
+// BenchmarkLooksLikePatient compares the resourceType pre-check, which rejects backend
+// records, with the full google/fhir unmarshal every record used to pay for.
+func BenchmarkLooksLikePatient(b *testing.B) {
+	patient, err := TransformBackendToFHIRPatient(backendRecord, "1000234")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("cheap check, backend record", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if LooksLikePatient(backendRecord) {
+				b.Fatal("backend record looks like a Patient")
+			}
+		}
+	})
+	b.Run("full check, FHIR Patient", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if !LooksLikePatient(patient) {
+				b.Fatal("Patient not recognized")
+			}
+		}
+	})
+	b.Run("full unmarshal, FHIR Patient", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := unmarshalR4(patient); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestValidateBundleR4(t *testing.T) {
+	bundle := func(typ, gender string) string {
+		return `{"resourceType":"Bundle","type":"` + typ + `","entry":[{"fullUrl":"urn:uuid:1",` +
+			`"resource":{"resourceType":"Patient","id":"123","gender":` + gender + `}}]}`
+	}
+	if err := ValidateBundleR4([]byte(bundle("searchset", `"male"`))); err != nil {
+		t.Fatalf("valid Bundle rejected: %v", err)
+	}
+	for name, data := range map[string]string{
+		"invalid entry gender": bundle("searchset", `"m"`),
+		"entry gender type":    bundle("searchset", `1`),
+		"unknown bundle type":  bundle("list", `"male"`),
+		"unknown field":        `{"resourceType":"Bundle","type":"searchset","entries":[]}`,
+		"not a Bundle":         `{"resourceType":"Patient","id":"123"}`,
+		"truncated":            bundle("searchset", `"male"`)[:40],
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := ValidateBundleR4([]byte(data)); err == nil {
+				t.Errorf("broken Bundle accepted: %s", data)
+			}
+		})
+	}
+}
+
+// adversarialPatients are malformed Patients that must be rejected with an error.
+var adversarialPatients = map[string]string{
+	"empty":                ``,
+	"top-level array":      `[{"resourceType":"Patient"}]`,
+	"resourceType number":  `{"resourceType":1}`,
+	"name is a string":     `{"resourceType":"Patient","name":"John"}`,
+	"name of numbers":      `{"resourceType":"Patient","name":[1,2]}`,
+	"extension not a list": `{"resourceType":"Patient","extension":{"url":1}}`,
+	"extension no url":     `{"resourceType":"Patient","extension":[{"valueString":"x"}]}`,
+	"contained garbage":    `{"resourceType":"Patient","contained":[{"resourceType":"Nope"},null,7]}`,
+	"deep nesting":         `{"resourceType":"Patient","extension":` + strings.Repeat("[", 10000) + strings.Repeat("]", 10000) + `}`,
+	"huge number":          `{"resourceType":"Patient","multipleBirthInteger":1e400}`,
+	"invalid UTF-8":        "{\"resourceType\":\"Patient\",\"id\":\"\xff\xfe\"}",
+	"NUL in id":            `{"resourceType":"Patient","id":"a\u0000b"}`,
+	"truncated":            `{"resourceType":"Patient","name":[{"given":["Jo`,
+}
+
+func TestAdversarialPatientsRejected(t *testing.T) {
+	for name, data := range adversarialPatients {
+		t.Run(name, func(t *testing.T) {
+			if err := ValidatePatientR4([]byte(data)); err == nil {
+				t.Error("ValidatePatientR4 accepted it")
+			}
+			if LooksLikePatient([]byte(data)) {
+				t.Error("LooksLikePatient accepted it")
+			}
+			if _, err := normalizeViaGoogleFHIR([]byte(data)); err == nil {
+				t.Error("normalizeViaGoogleFHIR accepted it")
+			}
+		})
+	}
+}
+
+func TestTransformAdversarialBackendRecords(t *testing.T) {
+	for name, data := range map[string]string{
+		"wrong field types": `{"data":{"upi":["123"],"firstName":{"x":1},"gender":7,"isDeceased":[],"dateOfBirth":true}}`,
+		"bad arrays":        `{"data":{"names":[1,null,"x"],"contacts":"x","relatedPersons":[[]],"mergedUpis":{"a":1}}}`,
+		"bad photo":         `{"data":{"photoBase64":"%%%","photoContentType":42}}`,
+		"envelope string":   `{"data":"{not json"}`,
+		"envelope list":     `{"data":[1]}`,
+		"not an object":     `"Patient"`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			out, err := TransformBackendToFHIRPatient([]byte(data), "123")
+			if err != nil {
+				return
+			}
+			if err := ValidatePatientR4(out); err != nil {
+				t.Errorf("transform returned an invalid Patient: %v\n%s", err, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"awesomeProject/internal/auth"
+)
+
+// SMARTAuth requires a valid SMART on FHIR bearer token on every request: reads
+// (GET/HEAD) need a Patient read scope, anything else a Patient write scope. Patient
+// is the only resource type served, so it is the one scopes are checked against.
+// The validated claims are stored on the context for the handlers and logs.
+func SMARTAuth(v *auth.Verifier, next http.Handler) http.Handler {
+	if v == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fhir"`)
+			writeOutcome(w, http.StatusUnauthorized, "login", "missing bearer token")
+			return
+		}
+		claims, err := v.Verify(r.Context(), token)
+		if err != nil {
+			logf(r, "Token rejected: %v", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fhir", error="invalid_token"`)
+			writeOutcome(w, http.StatusUnauthorized, "login", "invalid bearer token")
+			return
+		}
+		r = r.WithContext(auth.WithClaims(r.Context(), claims))
+		access := requiredAccess(r)
+		scope := "patient/Patient." + string(access)
+		if !claims.Allows("Patient", access) {
+			logf(r, "Insufficient scope for %s %s: have %q", r.Method, r.URL.Path, claims.Scopes)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fhir", error="insufficient_scope", scope="`+scope+`"`)
+			writeOutcome(w, http.StatusForbidden, "forbidden", "token lacks scope "+scope)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowPatient enforces a patient-scoped token's launch context: such tokens may only
+// read the patient they were issued for, and a patient/ scope without a patient claim
+// grants nothing. It writes a 403 and returns false otherwise.
+func allowPatient(w http.ResponseWriter, r *http.Request, id string) bool {
+	c := auth.FromContext(r.Context())
+	if c == nil || c.Patient == id {
+		return true
+	}
+	if c.Patient == "" {
+		if !c.PatientScoped("Patient", requiredAccess(r)) {
+			return true
+		}
+		logf(r, "Patient-scoped token without launch context patient id=%s", id)
+		writeOutcome(w, http.StatusForbidden, "forbidden", "patient-scoped token has no patient launch context")
+		return false
+	}
+	logf(r, "Patient %s outside token launch context patient=%s", id, c.Patient)
+	writeOutcome(w, http.StatusForbidden, "forbidden", "token is restricted to a different patient")
+	return false
+}
+
+// requiredAccess is the scope access r needs: read for GET/HEAD, write otherwise.
+func requiredAccess(r *http.Request) auth.Access {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return auth.Read
+	}
+	return auth.Write
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
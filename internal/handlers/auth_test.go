@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/auth"
+)
+
+// testTokens issues RS256 access tokens verifiable through its JWKS endpoint.
+type testTokens struct {
+	key *rsa.PrivateKey
+	v   *auth.Verifier
+}
+
+func newTestTokens(t *testing.T) *testTokens {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks := map[string]any{"keys": []any{map[string]string{
+		"kty": "RSA", "kid": "k1", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(srv.Close)
+	return &testTokens{key: key, v: &auth.Verifier{Issuer: "https://issuer.test", Keys: auth.NewKeySet(srv.URL, time.Second)}}
+}
+
+// token returns a signed token with the given scopes and launch context patient.
+func (tt *testTokens) token(t *testing.T, patient string, scopes ...string) string {
+	t.Helper()
+	enc := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	claims := map[string]any{
+		"iss": "https://issuer.test", "sub": "user-1", "exp": time.Now().Add(time.Hour).Unix(),
+		"scope": strings.Join(scopes, " "),
+	}
+	if patient != "" {
+		claims["patient"] = patient
+	}
+	input := enc(map[string]string{"alg": "RS256", "kid": "k1"}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, tt.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func authRequest(method, target, token string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestSMARTAuth(t *testing.T) {
+	tokens := newTestTokens(t)
+	d, _ := newDeps(samplePatient)
+	h := SMARTAuth(tokens.v, Routes(d))
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		status int
+		code   string
+	}{
+		{"no token", http.MethodGet, "", http.StatusUnauthorized, "login"},
+		{"garbage token", http.MethodGet, "not-a-jwt", http.StatusUnauthorized, "login"},
+		{"read scope", http.MethodGet, tokens.token(t, "", "user/Patient.read"), http.StatusOK, ""},
+		{"v2 read scope", http.MethodGet, tokens.token(t, "", "user/Patient.rs"), http.StatusOK, ""},
+		{"no Patient scope", http.MethodGet, tokens.token(t, "", "user/Observation.read"), http.StatusForbidden, "forbidden"},
+		{"write needs write scope", http.MethodPut, tokens.token(t, "", "user/Patient.read"), http.StatusForbidden, "forbidden"},
+		{"write scope", http.MethodPut, tokens.token(t, "", "user/Patient.write"), http.StatusMethodNotAllowed, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(t, h, authRequest(tc.method, "/fhir/Patient/123", tc.token))
+			if rec.Code != tc.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tc.status, rec.Body)
+			}
+			if tc.code != "" && !strings.Contains(rec.Body.String(), `"code":"`+tc.code+`"`) {
+				t.Errorf("body %s lacks code %q", rec.Body, tc.code)
+			}
+			if tc.status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	for in, want := range map[string]string{
+		"Bearer abc":   "abc",
+		"bearer  abc ": "abc",
+		"Basic abc":    "",
+		"Bearer":       "",
+		"":             "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", in)
+		if got, _ := bearerToken(r); got != want {
+			t.Errorf("bearerToken(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSMARTAuthLaunchContext(t *testing.T) {
+	tokens := newTestTokens(t)
+	d, _ := newDeps(samplePatient)
+	d.DebugOperations = true
+	h := SMARTAuth(tokens.v, Routes(d))
+	tests := []struct {
+		name   string
+		target string
+		token  string
+		status int
+	}{
+		{"own patient", "/fhir/Patient/123", tokens.token(t, "123", "patient/Patient.read"), http.StatusOK},
+		{"other patient", "/fhir/Patient/456", tokens.token(t, "123", "patient/Patient.read"), http.StatusForbidden},
+		{"other patient photo", "/fhir/Patient/456/photo", tokens.token(t, "123", "patient/Patient.read"), http.StatusForbidden},
+		{"other patient debug op", "/fhir/Patient/456/$validate-backend", tokens.token(t, "123", "patient/Patient.read"), http.StatusForbidden},
+		{"patient scope without claim", "/fhir/Patient/123", tokens.token(t, "", "patient/Patient.read"), http.StatusForbidden},
+		{"patient scope without claim photo", "/fhir/Patient/123/photo", tokens.token(t, "", "patient/Patient.read"), http.StatusForbidden},
+		{"user scope without claim", "/fhir/Patient/456", tokens.token(t, "", "user/Patient.read"), http.StatusOK},
+		{"user scope with other claim", "/fhir/Patient/456", tokens.token(t, "123", "user/Patient.read"), http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(t, h, authRequest(http.MethodGet, tc.target, tc.token))
+			if rec.Code != tc.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tc.status, rec.Body)
+			}
+			if tc.status == http.StatusForbidden && !strings.Contains(rec.Body.String(), `"code":"forbidden"`) {
+				t.Errorf("body %s lacks code forbidden", rec.Body)
+			}
+		})
+	}
+}
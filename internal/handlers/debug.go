@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"awesomeProject/internal/auth"
+	"awesomeProject/internal/fhir"
+)
+
+// validateBackendOp is the debug operation path suffix under Patient/{id}.
+const validateBackendOp = "/$validate-backend"
+
+// HandleValidateBackend serves GET <base>/Patient/{id}/$validate-backend: a Parameters
+// resource with the raw backend record, the transformed Patient and the validation
+// result. Unlike a normal read, transform and validation failures are reported in the
+// response instead of answered with 502, so support can see what went wrong. It
+// requires a token with auth.DebugScope, and both records are redacted as reads are.
+func (d *PatientDeps) HandleValidateBackend(w http.ResponseWriter, r *http.Request, id string) {
+	if !d.DebugOperations {
+		writeOutcome(w, http.StatusNotFound, "not-supported", "operation $validate-backend is not enabled")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	if c := auth.FromContext(r.Context()); c == nil || !c.HasScope(auth.DebugScope) {
+		logf(r, "AUDIT debug operation $validate-backend refused id=%s remote=%s", id, r.RemoteAddr)
+		writeOutcome(w, http.StatusForbidden, "forbidden", "operation $validate-backend requires scope "+auth.DebugScope)
+		return
+	}
+	start := time.Now()
+	logf(r, "AUDIT debug operation $validate-backend id=%s remote=%s", id, r.RemoteAddr)
+	body, beHeaders, ok := d.fetchPatient(w, r, id, start)
+	if !ok {
+		return
+	}
+	opts := d.Transform
+	opts.PreferredLanguage = recordLanguage(beHeaders)
+	var params, issues []any
+	if raw, err := fhir.RedactBackend(body, d.Redaction, opts); err != nil {
+		issues = append(issues, withheldIssue("backend record withheld: cannot redact it: "+err.Error()))
+	} else {
+		params = append(params, map[string]any{"name": "backend", "valueString": string(raw)})
+	}
+	valid := false
+	fhirJSON, err := fhir.TransformBackendToFHIRPatientWithOptions(body, id, opts)
+	if err != nil {
+		issues = append(issues, debugIssue("transform failed: "+err.Error()))
+	} else if err := fhir.ValidatePatientR4(fhirJSON); err != nil {
+		issues = append(issues, debugIssue("validation failed: "+err.Error()))
+		if d.Redaction.Empty() {
+			// An invalid Patient can't be embedded as a resource; return its JSON as text.
+			params = append(params, map[string]any{"name": "patient", "valueString": string(fhirJSON)})
+		} else {
+			// Redaction needs a valid Patient, so the invalid one can't be shown.
+			issues = append(issues, withheldIssue("patient withheld: an invalid Patient cannot be redacted"))
+		}
+	} else if fhirJSON, err = d.redact(r, fhirJSON); err != nil {
+		valid = true
+		issues = append(issues, withheldIssue("patient withheld: redaction failed: "+err.Error()))
+	} else {
+		valid = true
+		params = append(params, map[string]any{"name": "patient", "resource": json.RawMessage(fhirJSON)})
+	}
+	params = append(params, map[string]any{"name": "valid", "valueBoolean": valid})
+	if len(issues) > 0 {
+		params = append(params, map[string]any{"name": "issues", "resource": map[string]any{
+			"resourceType": "OperationOutcome",
+			"issue":        issues,
+		}})
+	}
+	w.Header().Set("Content-Type", fhirContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]any{"resourceType": "Parameters", "parameter": params})
+	d.logDone(r, start, "Debug $validate-backend id=%s valid=%t", id, valid)
+}
+
+// debugIssue builds an OperationOutcome issue for $validate-backend.
+func debugIssue(diagnostics string) map[string]any {
+	return map[string]any{"severity": "error", "code": "processing", "diagnostics": diagnostics}
+}
+
+// withheldIssue reports $validate-backend content left out because it can't be redacted.
+func withheldIssue(diagnostics string) map[string]any {
+	return map[string]any{"severity": "warning", "code": "suppressed", "diagnostics": diagnostics}
+}
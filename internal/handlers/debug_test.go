@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"awesomeProject/internal/auth"
+)
+
+// debugRequest is a $validate-backend request authenticated with scopes.
+func debugRequest(scopes ...string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/fhir/Patient/123/$validate-backend", nil)
+	if scopes != nil {
+		req = req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{Subject: "admin", Scopes: scopes}))
+	}
+	return req
+}
+
+// debugParams decodes a Parameters response into name -> parameter.
+func debugParams(t *testing.T, rec *httptest.ResponseRecorder) map[string]map[string]any {
+	t.Helper()
+	var res struct {
+		Parameter []map[string]any `json:"parameter"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode %s: %v", rec.Body, err)
+	}
+	params := make(map[string]map[string]any, len(res.Parameter))
+	for _, p := range res.Parameter {
+		params[p["name"].(string)] = p
+	}
+	return params
+}
+
+func TestValidateBackendAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		req     *http.Request
+		status  int
+	}{
+		{"disabled", false, debugRequest(auth.DebugScope), http.StatusNotFound},
+		{"unauthenticated", true, debugRequest(), http.StatusForbidden},
+		{"without debug scope", true, debugRequest("user/Patient.read"), http.StatusForbidden},
+		{"with debug scope", true, debugRequest("user/Patient.read", auth.DebugScope), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, be := newDeps(samplePatient)
+			d.DebugOperations = tt.enabled
+			rec := serve(t, Routes(d), tt.req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK && be.calls != 0 {
+				t.Errorf("backend fetched for a refused request")
+			}
+		})
+	}
+}
+
+func TestValidateBackendRedaction(t *testing.T) {
+	d, _ := newDeps(samplePatient)
+	d.DebugOperations = true
+	d.Redaction.Elements = []string{"telecom"}
+	d.Redaction.IdentifierSystems = []string{"urn:national"}
+	rec := serve(t, Routes(d), debugRequest(auth.DebugScope))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; body %s", rec.Code, rec.Body)
+	}
+	params := debugParams(t, rec)
+	if params["backend"] == nil || params["patient"] == nil {
+		t.Fatalf("missing backend or patient parameter: %s", rec.Body)
+	}
+	for _, secret := range []string{"1010101010", "0501234567"} {
+		if strings.Contains(rec.Body.String(), secret) {
+			t.Errorf("redacted value %s in response %s", secret, rec.Body)
+		}
+	}
+	if !strings.Contains(params["backend"]["valueString"].(string), `"upi":"123"`) {
+		t.Errorf("unredacted backend field missing: %v", params["backend"])
+	}
+}
+
+func TestValidateBackendWithholdsUnredactableRecord(t *testing.T) {
+	d, _ := newDeps(samplePatient)
+	d.DebugOperations = true
+	d.Redaction.Elements = []string{"multipleBirth"}
+	rec := serve(t, Routes(d), debugRequest(auth.DebugScope))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; body %s", rec.Code, rec.Body)
+	}
+	params := debugParams(t, rec)
+	if params["backend"] != nil {
+		t.Errorf("backend record returned although its redaction is unknown: %v", params["backend"])
+	}
+	if params["valid"]["valueBoolean"] != true || !strings.Contains(rec.Body.String(), `"code":"suppressed"`) {
+		t.Errorf("withheld record not reported: %s", rec.Body)
+	}
+}
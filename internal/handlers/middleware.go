@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"awesomeProject/internal/beclient"
+)
+
+// WithTimeout bounds the whole request by d. Handlers see the deadline on the request
+// context, which the backend client honors, and answer 504 once it has passed.
+func WithTimeout(d time.Duration, next http.Handler) http.Handler {
+	if d <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// deadlineExceeded reports whether the request's deadline has passed.
+func deadlineExceeded(r *http.Request) bool {
+	return errors.Is(r.Context().Err(), context.DeadlineExceeded)
+}
+
+// Recover turns a panic in any downstream handler into a 500 OperationOutcome
+// instead of letting net/http drop the connection.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			logf(r, "Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+			writeOutcome(w, http.StatusInternalServerError, "exception", "internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequestIDHeader carries the correlation id on incoming requests and responses.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID reuses the incoming X-Request-ID (or generates one), echoes it on the
+// response, and stores it on the context so logs and backend calls carry it.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := r.Header.Get(RequestIDHeader)
+		if rid == "" {
+			rid = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, rid)
+		next.ServeHTTP(w, r.WithContext(beclient.WithCorrelationID(r.Context(), rid)))
+	})
+}
+
+// newRequestID returns a random 128-bit hex id.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Pretty indents FHIR JSON responses when the client asks with _pretty=true, or by
+// default when prettyByDefault is set (_pretty=false opts out). Responses that aren't
+// prettified pass through untouched, keeping already-FHIR payloads byte-identical.
+func Pretty(prettyByDefault bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pretty := prettyByDefault
+		if v := r.URL.Query().Get("_pretty"); v != "" {
+			pretty = v == "true"
+		}
+		if !pretty {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pw := &prettyWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(pw, r)
+		pw.flush()
+	})
+}
+
+// prettyWriter buffers a response so a JSON body can be re-indented before sending.
+type prettyWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (pw *prettyWriter) WriteHeader(status int) { pw.status = status }
+
+func (pw *prettyWriter) Write(b []byte) (int, error) { return pw.buf.Write(b) }
+
+func (pw *prettyWriter) flush() {
+	body := pw.buf.Bytes()
+	if strings.Contains(pw.Header().Get("Content-Type"), "json") {
+		var out bytes.Buffer
+		if err := json.Indent(&out, body, "", "  "); err == nil {
+			body = out.Bytes()
+			pw.Header().Del("Content-Length")
+		}
+	}
+	pw.ResponseWriter.WriteHeader(pw.status)
+	_, _ = pw.ResponseWriter.Write(body)
+}
+
+// RejectBodyOnGetDelete answers 400 when a GET or DELETE request carries a body.
+// Such bodies are otherwise silently ignored, which hides client bugs.
+func RejectBodyOnGetDelete(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodDelete {
+			if hasBody(r) {
+				writeSimpleOutcome(w, http.StatusBadRequest, r.Method+" requests must not include a body")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hasBody reports whether r carries a non-empty body. For bodies of unknown
+// length (chunked) it peeks a single byte.
+func hasBody(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		return false
+	}
+	if r.ContentLength > 0 {
+		return true
+	}
+	if r.ContentLength == 0 {
+		return false
+	}
+	var b [1]byte
+	n, _ := io.ReadFull(r.Body, b[:])
+	return n > 0
+}
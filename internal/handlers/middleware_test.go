@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]any
+		m["boom"] = 1 // nil map write, as a malformed payload would trigger
+	})
+	srv := httptest.NewServer(RequestID(Recover(panicking)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/fhir/Patient/1")
+	if err != nil {
+		t.Fatalf("connection dropped instead of a 500: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", resp.StatusCode)
+	}
+	if resp.Header.Get(RequestIDHeader) == "" {
+		t.Error("no request id on the 500")
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var oo struct {
+		ResourceType string
+		Issue        []struct{ Code string }
+	}
+	if err := json.Unmarshal(body, &oo); err != nil {
+		t.Fatalf("decode %s: %v", body, err)
+	}
+	if oo.ResourceType != "OperationOutcome" || len(oo.Issue) != 1 || oo.Issue[0].Code != "exception" {
+		t.Errorf("body = %s, want an exception OperationOutcome", body)
+	}
+}
+
+func TestRecoverRepanicsAbortHandler(t *testing.T) {
+	h := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if rec := recover(); rec != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", rec)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -1,96 +1,433 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"mime"
 	"net/http"
 	"strings"
 	"time"
 
+	"awesomeProject/internal/auth"
 	"awesomeProject/internal/beclient"
 	"awesomeProject/internal/fhir"
 )
 
+// fhirContentType is sent on every FHIR response, advertising the R4 version.
+const fhirContentType = "application/fhir+json; fhirVersion=4.0"
+
 // PatientDeps holds dependencies required by the HTTP handlers.
 type PatientDeps struct {
-	BE beclient.Client
+	BE        beclient.Client
+	Transform fhir.TransformOptions
+	// BasePath is where the FHIR routes are mounted (default "/fhir"); "/" mounts them
+	// at the root.
+	BasePath string
+	// SlowRequestThreshold, when positive, limits routine logging to requests slower
+	// than the threshold (logged as WARN). Zero logs every request. Errors always log.
+	SlowRequestThreshold time.Duration
+	// Redaction withholds sensitive Patient content from every response.
+	Redaction fhir.Redaction
+	// PassthroughBackendAuthErrors forwards backend 401/403 responses as-is instead of
+	// answering 502 "backend authentication failed".
+	PassthroughBackendAuthErrors bool
+	// DebugOperations enables the $validate-backend troubleshooting operation, which
+	// exposes raw backend records to tokens with auth.DebugScope (so it needs SMARTAuth).
+	DebugOperations bool
+}
+
+// redact applies the configured Redaction to a validated Patient.
+func (d *PatientDeps) redact(r *http.Request, data []byte) ([]byte, error) {
+	out, redacted, err := fhir.RedactPatient(data, d.Redaction)
+	if redacted {
+		d.logProgress(r, "Patient redacted elements=%v identifierSystems=%v", d.Redaction.Elements, d.Redaction.IdentifierSystems)
+	}
+	return out, err
+}
+
+// logProgress logs a routine step; it is silenced once a slow-request threshold is set.
+func (d *PatientDeps) logProgress(r *http.Request, format string, args ...any) {
+	if d.SlowRequestThreshold <= 0 {
+		logf(r, format, args...)
+	}
+}
+
+// logDone logs the successful end of a request with its duration, subject to
+// SlowRequestThreshold.
+func (d *PatientDeps) logDone(r *http.Request, start time.Time, format string, args ...any) {
+	elapsed := time.Since(start)
+	switch {
+	case d.SlowRequestThreshold <= 0:
+		logf(r, format+" duration=%s", append(args, elapsed)...)
+	case elapsed >= d.SlowRequestThreshold:
+		logf(r, "WARN slow request: "+format+" duration=%s threshold=%s", append(args, elapsed, d.SlowRequestThreshold)...)
+	}
+}
+
+// logf logs with the request's correlation id, so our lines match the backend's,
+// and the token subject when the request is authenticated.
+func logf(r *http.Request, format string, args ...any) {
+	if c := auth.FromContext(r.Context()); c != nil && c.Subject != "" {
+		format = "sub=" + c.Subject + " " + format
+	}
+	if rid := beclient.CorrelationID(r.Context()); rid != "" {
+		format = "rid=" + rid + " " + format
+	}
+	log.Printf(format, args...)
+}
+
+// basePath returns the configured FHIR base with a leading and no trailing slash,
+// so the root is "".
+func (d *PatientDeps) basePath() string {
+	if d.BasePath == "" {
+		return "/fhir"
+	}
+	if p := strings.Trim(d.BasePath, "/"); p != "" {
+		return "/" + p
+	}
+	return ""
 }
 
 func (d *PatientDeps) HandlePatientByID(w http.ResponseWriter, r *http.Request) {
-	prefix := "/fhir/Patient/"
+	prefix := d.basePath() + "/Patient/"
 	if !strings.HasPrefix(r.URL.Path, prefix) {
 		writeSimpleOutcome(w, http.StatusBadRequest, "invalid path")
 		return
 	}
 	id := strings.TrimPrefix(r.URL.Path, prefix)
+	if pid, ok := strings.CutSuffix(id, "/photo"); ok && pid != "" && !strings.Contains(pid, "/") {
+		if allowPatient(w, r, pid) {
+			d.HandlePatientPhoto(w, r, pid)
+		}
+		return
+	}
+	if pid, ok := strings.CutSuffix(id, validateBackendOp); ok && pid != "" && !strings.Contains(pid, "/") {
+		if allowPatient(w, r, pid) {
+			d.HandleValidateBackend(w, r, pid)
+		}
+		return
+	}
 	if id == "" || strings.Contains(id, "/") {
 		writeSimpleOutcome(w, http.StatusBadRequest, "missing or invalid patient id")
 		return
 	}
+	if !allowPatient(w, r, id) {
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		start := time.Now()
-		log.Printf("Start fetching Patient id=%s", id)
-		status, body, _, err := d.BE.GetPatient(r.Context(), id, r.Header)
+		d.logProgress(r, "Start fetching Patient id=%s", id)
+		body, beHeaders, ok := d.fetchPatient(w, r, id, start)
+		if !ok {
+			return
+		}
+		opts := d.Transform
+		opts.PreferredLanguage = recordLanguage(beHeaders)
+		fhirJSON, err := fhir.TransformBackendToFHIRPatientWithOptions(body, id, opts)
+		if deadlineExceeded(r) {
+			logf(r, "Transform exceeded request deadline id=%s duration=%s", id, time.Since(start))
+			writeOutcome(w, http.StatusGatewayTimeout, "timeout", "request deadline exceeded")
+			return
+		}
 		if err != nil {
-			log.Printf("Fetch failed (transport) id=%s err=%v duration=%s", id, err, time.Since(start))
-			writeSimpleOutcome(w, http.StatusBadGateway, "backend service unavailable")
+			logf(r, "Transform to FHIR failed id=%s err=%v duration=%s", id, err, time.Since(start))
+			writeOutcome(w, http.StatusBadGateway, "processing", "failed to transform backend response to FHIR Patient")
 			return
 		}
-		if status == http.StatusNotFound {
-			log.Printf("Patient not found id=%s duration=%s", id, time.Since(start))
-			writeSimpleOutcome(w, http.StatusNotFound, "Patient not found in backend")
+		if err := fhir.ValidatePatientR4(fhirJSON); err != nil {
+			logf(r, "FHIR validation failed id=%s err=%v duration=%s", id, err, time.Since(start))
+			writeOutcome(w, http.StatusBadGateway, "processing", "generated Patient failed FHIR R4 validation")
 			return
 		}
-		if status >= 200 && status < 300 {
-			log.Printf("Backend response ok id=%s status=%d bytes=%d", id, status, len(body))
-			fhirJSON, err := fhir.TransformBackendToFHIRPatient(body, id)
-			if err != nil {
-				log.Printf("Transform to FHIR failed id=%s err=%v duration=%s", id, err, time.Since(start))
-				writeSimpleOutcome(w, http.StatusBadGateway, "failed to transform backend response to FHIR Patient")
-				return
-			}
-			if err := fhir.ValidatePatientR4(fhirJSON); err != nil {
-				log.Printf("FHIR validation failed id=%s err=%v duration=%s", id, err, time.Since(start))
-				writeSimpleOutcome(w, http.StatusBadGateway, "generated Patient failed FHIR R4 validation")
+		// Redaction runs after validation, so the full resource was still checked.
+		if fhirJSON, err = d.redact(r, fhirJSON); err != nil {
+			logf(r, "Redaction failed id=%s err=%v duration=%s", id, err, time.Since(start))
+			writeSimpleOutcome(w, http.StatusInternalServerError, "failed to redact Patient")
+			return
+		}
+		if elements, ok, err := projection(r); err != nil {
+			writeOutcome(w, http.StatusBadRequest, "not-supported", err.Error())
+			return
+		} else if ok {
+			if fhirJSON, err = fhir.ProjectPatient(fhirJSON, elements); err != nil {
+				logf(r, "Projection failed id=%s err=%v duration=%s", id, err, time.Since(start))
+				writeSimpleOutcome(w, http.StatusInternalServerError, "failed to apply _elements/_summary")
 				return
 			}
-			w.Header().Set("Content-Type", "application/fhir+json")
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(fhirJSON)
-			log.Printf("Fetch success id=%s duration=%s", id, time.Since(start))
+		}
+		etag := contentETag(fhirJSON)
+		w.Header().Set("ETag", etag)
+		// The record is fetched in the caller's language (beclient backendLang).
+		w.Header().Set("Vary", "Accept-Language")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			d.logDone(r, start, "Not modified id=%s", id)
 			return
 		}
+		w.Header().Set("Content-Type", fhirContentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(fhirJSON)
+		d.logDone(r, start, "Fetch success id=%s", id)
+		return
+
+	default:
+		writeMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+}
+
+// HandlePatientPhoto serves GET <base>/Patient/{id}/photo: the raw image bytes of the
+// patient's photo, with Range and conditional request support via http.ServeContent.
+// Photos the backend only references by URL are answered with a redirect.
+func (d *PatientDeps) HandlePatientPhoto(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	if d.Redaction.Redacts("photo") {
+		// The bytes are withheld along with the Patient.photo element.
+		d.logProgress(r, "Patient photo redacted id=%s", id)
+		writeSimpleOutcome(w, http.StatusNotFound, "Patient has no photo")
+		return
+	}
+	start := time.Now()
+	d.logProgress(r, "Start fetching Patient photo id=%s", id)
+	body, _, ok := d.fetchPatient(w, r, id, start)
+	if !ok {
+		return
+	}
+	photo, err := fhir.ExtractPatientPhotoWithOptions(body, d.Transform)
+	if errors.Is(err, fhir.ErrNoPhoto) {
+		d.logDone(r, start, "Patient has no photo id=%s", id)
+		writeSimpleOutcome(w, http.StatusNotFound, "Patient has no photo")
+		return
+	}
+	if err != nil {
+		logf(r, "Photo extraction failed id=%s err=%v duration=%s", id, err, time.Since(start))
+		writeOutcome(w, http.StatusBadGateway, "processing", "failed to decode backend patient photo")
+		return
+	}
+	if photo.URL != "" {
+		http.Redirect(w, r, photo.URL, http.StatusFound)
+		return
+	}
+	ct := photo.ContentType
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	sum := sha256.Sum256(photo.Data)
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(photo.Data))
+	d.logDone(r, start, "Photo served id=%s bytes=%d", id, len(photo.Data))
+}
+
+// projection returns the elements requested via _summary or _elements.
+// ok is false when the full resource should be returned. _summary=text is
+// refused: the transform generates no narrative, so it would return nothing.
+func projection(r *http.Request) (elements []string, ok bool, err error) {
+	q := r.URL.Query()
+	switch summary := q.Get("_summary"); summary {
+	case "", "false":
+	case "true":
+		return fhir.PatientSummaryElements, true, nil
+	case "data":
+		return fhir.PatientDataElements, true, nil
+	default:
+		return nil, false, fmt.Errorf("unsupported _summary value %q", summary)
+	}
+	if v := q.Get("_elements"); v != "" {
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				elements = append(elements, e)
+			}
+		}
+		return elements, true, nil
+	}
+	return nil, false, nil
+}
+
+// contentETag derives a weak ETag from the response body. Backend reads carry no
+// versionId, so the transformed content itself is the version.
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches applies the weak comparison of If-None-Match against etag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchPatient fetches the backend record for id, returning it with the backend
+// response headers. On any failure it writes the client response itself and
+// returns ok=false.
+func (d *PatientDeps) fetchPatient(w http.ResponseWriter, r *http.Request, id string, start time.Time) ([]byte, http.Header, bool) {
+	status, body, beHeaders, err := d.BE.GetPatient(r.Context(), id, r.Header)
+	if err != nil && deadlineExceeded(r) {
+		logf(r, "Fetch timed out id=%s err=%v duration=%s", id, err, time.Since(start))
+		writeOutcome(w, http.StatusGatewayTimeout, "timeout", "backend did not answer within the request deadline")
+		return nil, nil, false
+	}
+	if err != nil {
+		logf(r, "Fetch failed (transport) id=%s err=%v duration=%s", id, err, time.Since(start))
+		writeSimpleOutcome(w, http.StatusBadGateway, "backend service unavailable")
+		return nil, nil, false
+	}
+	if status == http.StatusNotFound {
+		d.logDone(r, start, "Patient not found id=%s", id)
+		writeSimpleOutcome(w, http.StatusNotFound, "Patient not found in backend")
+		return nil, nil, false
+	}
+	if ct := beHeaders.Get("Content-Type"); !looksLikeJSON(ct, body) {
+		// Typically a proxy's HTML error page; don't forward it or try to transform it.
+		logf(r, "Backend non-JSON response id=%s status=%d content-type=%q bytes=%d duration=%s", id, status, ct, len(body), time.Since(start))
+		if ct == "" {
+			ct = "none"
+		}
+		writeOutcome(w, http.StatusBadGateway, "exception",
+			fmt.Sprintf("unexpected backend content type %s (status %d)", ct, status))
+		return nil, nil, false
+	}
+	if (status == http.StatusUnauthorized || status == http.StatusForbidden) && !d.PassthroughBackendAuthErrors {
+		// Our credentials were rejected upstream; that's not the client's auth problem.
+		logf(r, "Backend rejected our credentials id=%s status=%d duration=%s", id, status, time.Since(start))
+		writeOutcome(w, http.StatusBadGateway, "exception", "backend authentication failed")
+		return nil, nil, false
+	}
+	if status < 200 || status >= 300 {
 		// Forward non-success
-		log.Printf("Backend non-success id=%s status=%d bytes=%d duration=%s", id, status, len(body), time.Since(start))
+		logf(r, "Backend non-success id=%s status=%d bytes=%d duration=%s", id, status, len(body), time.Since(start))
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(status)
 		_, _ = w.Write(body)
-		return
+		return nil, nil, false
+	}
+	if endpoint := beHeaders.Get(beclient.ServedByHeader); endpoint != "" {
+		d.logProgress(r, "Backend response ok id=%s status=%d bytes=%d endpoint=%s", id, status, len(body), endpoint)
+	} else {
+		d.logProgress(r, "Backend response ok id=%s status=%d bytes=%d", id, status, len(body))
+	}
+	return body, beHeaders, true
+}
 
-	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
+// looksLikeJSON reports whether a backend response is JSON: an HTML page, or a body
+// that doesn't open with an object or array, is not. Other declared types are ignored,
+// since backends often label JSON text/plain.
+func looksLikeJSON(contentType string, body []byte) bool {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil && mt == "text/html" {
+		return false
+	}
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	if len(trimmed) == 0 {
+		// Empty bodies are left to the status handling (and the transform) to report.
+		return true
+	}
+	return trimmed[0] == '{' || trimmed[0] == '['
+}
+
+// recordLanguage returns the language the backend record is localized in: its
+// Content-Language, else the lang the client requested it in.
+func recordLanguage(h http.Header) string {
+	if cl := h.Get("Content-Language"); cl != "" {
+		first, _, _ := strings.Cut(cl, ",")
+		return strings.TrimSpace(first)
 	}
+	return h.Get(beclient.LangHeader)
+}
+
+// supportedResourceTypes lists the resource types this server serves.
+var supportedResourceTypes = []string{"Patient"}
+
+// handleUnsupportedResource answers any other path under the FHIR base with a
+// not-supported OperationOutcome instead of the ServeMux's blank 404.
+func handleUnsupportedResource(w http.ResponseWriter, r *http.Request) {
+	writeOutcome(w, http.StatusNotFound, "not-supported",
+		"unsupported resource or path "+r.URL.Path+"; supported resource types: "+strings.Join(supportedResourceTypes, ", "))
 }
 
 // Routes registers HTTP routes for Patient.
 func Routes(deps *PatientDeps) http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/fhir/Patient/", deps.HandlePatientByID)
+	mux.HandleFunc(deps.basePath()+"/Patient/", deps.HandlePatientByID)
+	mux.HandleFunc(deps.basePath()+"/", handleUnsupportedResource)
 	return mux
 }
 
-// writeSimpleOutcome sends a minimal OperationOutcome JSON
+// writeSimpleOutcome sends a minimal OperationOutcome JSON with the issue code the
+// status implies. Call writeOutcome directly when a more specific code applies.
 func writeSimpleOutcome(w http.ResponseWriter, status int, diagnostics string) {
-	w.Header().Set("Content-Type", "application/fhir+json")
+	writeOutcome(w, status, issueCodeForStatus(status), diagnostics)
+}
+
+// statusIssueCodes maps HTTP statuses onto the OperationOutcome issue type that
+// usually explains them.
+var statusIssueCodes = map[int]string{
+	http.StatusBadRequest:            "invalid",
+	http.StatusUnauthorized:          "login",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not-found",
+	http.StatusMethodNotAllowed:      "not-supported",
+	http.StatusNotAcceptable:         "not-supported",
+	http.StatusRequestTimeout:        "timeout",
+	http.StatusConflict:              "conflict",
+	http.StatusPreconditionFailed:    "conflict",
+	http.StatusGone:                  "deleted",
+	http.StatusRequestEntityTooLarge: "too-costly",
+	http.StatusUnprocessableEntity:   "processing",
+	http.StatusTooManyRequests:       "throttled",
+	http.StatusInternalServerError:   "exception",
+	http.StatusBadGateway:            "transient",
+	http.StatusServiceUnavailable:    "transient",
+	http.StatusGatewayTimeout:        "timeout",
+}
+
+// issueCodeForStatus returns the issue code for status, falling back to invalid for
+// other client errors and exception for server errors.
+func issueCodeForStatus(status int) string {
+	if code, ok := statusIssueCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return "exception"
+	}
+	return "invalid"
+}
+
+// writeMethodNotAllowed sends a 405 OperationOutcome with the Allow header listing
+// the methods the route supports.
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeOutcome(w, http.StatusMethodNotAllowed, "not-supported", "method "+r.Method+" not allowed; allowed: "+strings.Join(allowed, ", "))
+}
+
+// writeOutcome sends a minimal OperationOutcome JSON with the given issue code.
+func writeOutcome(w http.ResponseWriter, status int, code, diagnostics string) {
+	w.Header().Set("Content-Type", fhirContentType)
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(map[string]any{
 		"resourceType": "OperationOutcome",
 		"issue": []any{
 			map[string]any{
 				"severity":    "error",
-				"code":        "invalid",
+				"code":        code,
 				"diagnostics": diagnostics,
 			},
 		},
@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/beclient"
+	"awesomeProject/internal/fhir"
+)
+
+// samplePatient is a backend record in the EMPI envelope.
+const samplePatient = `{"data":{"upi":"123","firstName":"John","lastName":"Doe","gender":"male",` +
+	`"dateOfBirth":"1980-02-03","mobileNumber":"0501234567","idType":"national","idNumber":"1010101010"}}`
+
+// fakeBE is a beclient.Client answering every GetPatient with a canned response.
+type fakeBE struct {
+	status  int
+	body    string
+	headers http.Header
+	err     error
+
+	calls     int
+	inHeaders http.Header
+}
+
+func (f *fakeBE) GetPatient(ctx context.Context, id string, inHeaders http.Header) (int, []byte, http.Header, error) {
+	f.calls++
+	f.inHeaders = inHeaders
+	if f.err != nil {
+		return 0, nil, nil, f.err
+	}
+	h := f.headers
+	if h == nil {
+		h = http.Header{"Content-Type": {"application/json"}}
+	}
+	status := f.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return status, []byte(f.body), h, nil
+}
+
+func (f *fakeBE) Probe(ctx context.Context) error { return f.err }
+
+// newDeps returns PatientDeps over a fake backend serving body.
+func newDeps(body string) (*PatientDeps, *fakeBE) {
+	be := &fakeBE{body: body}
+	return &PatientDeps{BE: be, Transform: fhir.DefaultTransformOptions()}, be
+}
+
+// serve runs req through h and returns the recorded response.
+func serve(t *testing.T, h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGetPatient(t *testing.T) {
+	d, _ := newDeps(samplePatient)
+	rec := serve(t, Routes(d), httptest.NewRequest(http.MethodGet, "/fhir/Patient/123", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != fhirContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if rec.Header().Get("ETag") == "" {
+		t.Error("no ETag")
+	}
+}
+
+// photoPatient carries a 1x1 PNG as the backend photo.
+const photoPatient = `{"data":{"upi":"123","firstName":"John","photoBase64":` +
+	`"iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg=="}}`
+
+func TestPatientPhotoRedaction(t *testing.T) {
+	tests := []struct {
+		name     string
+		elements []string
+		status   int
+	}{
+		{"not redacted", nil, http.StatusOK},
+		{"photo redacted", []string{"telecom", "photo"}, http.StatusNotFound},
+		{"other elements redacted", []string{"telecom"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, be := newDeps(photoPatient)
+			d.Redaction.Elements = tt.elements
+			rec := serve(t, Routes(d), httptest.NewRequest(http.MethodGet, "/fhir/Patient/123/photo", nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusOK && rec.Header().Get("Content-Type") != "image/png" {
+				t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+			}
+			if tt.status == http.StatusNotFound && be.calls != 0 {
+				t.Errorf("backend called %d times for a redacted photo", be.calls)
+			}
+		})
+	}
+}
+
+func TestGetPatientPreferredLanguage(t *testing.T) {
+	var gotLang string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotLang = r.URL.Query().Get("lang")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":{"upi":"123","firstName":"John","language":"Arabic"}}`))
+	}))
+	defer srv.Close()
+	d := &PatientDeps{BE: beclient.NewHTTPClient(srv.URL, time.Second, false, nil), Transform: fhir.DefaultTransformOptions()}
+
+	tests := []struct {
+		acceptLanguage string
+		lang           string
+		preferred      bool
+	}{
+		{"ar", "ar", true},
+		{"ar-SA, en;q=0.5", "ar", true},
+		{"en", "en", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptLanguage, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/fhir/Patient/123", nil)
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+			rec := serve(t, Routes(d), req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d; body %s", rec.Code, rec.Body)
+			}
+			if gotLang != tt.lang {
+				t.Errorf("backend lang = %q, want %q", gotLang, tt.lang)
+			}
+			var p struct {
+				Communication []struct {
+					Language  struct{ Coding []struct{ Code string } }
+					Preferred bool
+				}
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Communication) != 1 || p.Communication[0].Language.Coding[0].Code != "ar" {
+				t.Fatalf("communication = %+v, want only Arabic", p.Communication)
+			}
+			if p.Communication[0].Preferred != tt.preferred {
+				t.Errorf("preferred = %v, want %v", p.Communication[0].Preferred, tt.preferred)
+			}
+			if v := rec.Header().Get("Vary"); v != "Accept-Language" {
+				t.Errorf("Vary = %q, want Accept-Language", v)
+			}
+		})
+	}
+}
+
+func TestRoutesBasePath(t *testing.T) {
+	tests := []struct {
+		basePath string
+		target   string
+		status   int
+	}{
+		{"", "/fhir/Patient/123", http.StatusOK},
+		{"/emr/fhir", "/emr/fhir/Patient/123", http.StatusOK},
+		{"emr/fhir/", "/emr/fhir/Patient/123", http.StatusOK},
+		{"/emr/fhir", "/emr/fhir/Observation/1", http.StatusNotFound},
+		{"/emr/fhir", "/fhir/Patient/123", http.StatusNotFound},
+		{"/", "/Patient/123", http.StatusOK},
+		{"/", "/Observation/1", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.basePath+" "+tt.target, func(t *testing.T) {
+			d, _ := newDeps(samplePatient)
+			d.BasePath = tt.basePath
+			rec := serve(t, Routes(d), httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusOK && !strings.Contains(rec.Body.String(), `"id":"123"`) {
+				t.Errorf("body %s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestGetPatientProjection(t *testing.T) {
+	tests := []struct {
+		query   string
+		status  int
+		has     []string
+		missing []string
+	}{
+		{"", http.StatusOK, []string{"telecom", "birthDate"}, []string{"SUBSETTED"}},
+		{"?_summary=false", http.StatusOK, []string{"telecom"}, []string{"SUBSETTED"}},
+		{"?_summary=true", http.StatusOK, []string{"name", "SUBSETTED"}, nil},
+		{"?_summary=data", http.StatusOK, []string{"name", "telecom", "SUBSETTED"}, []string{`"text"`}},
+		{"?_summary=text", http.StatusBadRequest, []string{"not-supported"}, nil},
+		{"?_summary=count", http.StatusBadRequest, []string{"not-supported"}, nil},
+		{"?_elements=gender", http.StatusOK, []string{"gender", "SUBSETTED"}, []string{"telecom", "birthDate"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			d, _ := newDeps(samplePatient)
+			rec := serve(t, Routes(d), httptest.NewRequest(http.MethodGet, "/fhir/Patient/123"+tt.query, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body)
+			}
+			for _, s := range tt.has {
+				if !strings.Contains(rec.Body.String(), s) {
+					t.Errorf("body lacks %s: %s", s, rec.Body)
+				}
+			}
+			for _, s := range tt.missing {
+				if strings.Contains(rec.Body.String(), s) {
+					t.Errorf("body has %s: %s", s, rec.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestGetPatientBackendContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"application/json", samplePatient, http.StatusOK},
+		{"application/fhir+json; charset=utf-8", samplePatient, http.StatusOK},
+		{"text/plain", samplePatient, http.StatusOK},
+		{"", samplePatient, http.StatusOK},
+		{"text/html; charset=utf-8", samplePatient, http.StatusBadGateway},
+		{"text/plain", "<html><body>Bad Gateway</body></html>", http.StatusBadGateway},
+		{"application/json", "Service Unavailable", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType+" "+tt.body[:6], func(t *testing.T) {
+			d, be := newDeps(tt.body)
+			be.headers = http.Header{"Content-Type": {tt.contentType}}
+			rec := serve(t, Routes(d), httptest.NewRequest(http.MethodGet, "/fhir/Patient/123", nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestWriteSimpleOutcomeIssueCodes(t *testing.T) {
+	tests := []struct {
+		status int
+		code   string
+	}{
+		{http.StatusBadRequest, "invalid"},
+		{http.StatusUnauthorized, "login"},
+		{http.StatusForbidden, "forbidden"},
+		{http.StatusNotFound, "not-found"},
+		{http.StatusMethodNotAllowed, "not-supported"},
+		{http.StatusNotAcceptable, "not-supported"},
+		{http.StatusRequestTimeout, "timeout"},
+		{http.StatusConflict, "conflict"},
+		{http.StatusPreconditionFailed, "conflict"},
+		{http.StatusGone, "deleted"},
+		{http.StatusRequestEntityTooLarge, "too-costly"},
+		{http.StatusUnprocessableEntity, "processing"},
+		{http.StatusTooManyRequests, "throttled"},
+		{http.StatusInternalServerError, "exception"},
+		{http.StatusBadGateway, "transient"},
+		{http.StatusServiceUnavailable, "transient"},
+		{http.StatusGatewayTimeout, "timeout"},
+		{http.StatusTeapot, "invalid"},           // unmapped 4xx
+		{http.StatusNotImplemented, "exception"}, // unmapped 5xx
+		{http.StatusHTTPVersionNotSupported, "exception"},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeSimpleOutcome(rec, tt.status, "diagnostics")
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			var oo struct {
+				ResourceType string
+				Issue        []struct{ Severity, Code string }
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &oo); err != nil {
+				t.Fatal(err)
+			}
+			if oo.ResourceType != "OperationOutcome" || len(oo.Issue) != 1 || oo.Issue[0].Code != tt.code {
+				t.Errorf("outcome = %+v, want one issue with code %q", oo, tt.code)
+			}
+		})
+	}
+}
+
+func TestGetPatientConditional(t *testing.T) {
+	d, _ := newDeps(samplePatient)
+	rec := serve(t, Routes(d), httptest.NewRequest(http.MethodGet, "/fhir/Patient/123", nil))
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	strong := strings.TrimPrefix(etag, "W/")
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		status      int
+	}{
+		{"strong match", strong, http.StatusNotModified},
+		{"weak match", "W/" + strong, http.StatusNotModified},
+		{"in a list", `"other", ` + etag, http.StatusNotModified},
+		{"any", "*", http.StatusNotModified},
+		{"no match", `"other"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/fhir/Patient/123", nil)
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			rec := serve(t, Routes(d), req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Errorf("304 with a body: %s", rec.Body)
+			}
+			if tt.status == http.StatusOK && rec.Body.Len() == 0 {
+				t.Error("200 without a body")
+			}
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+			if v := rec.Header().Get("Vary"); v != "Accept-Language" {
+				t.Errorf("Vary = %q, want Accept-Language", v)
+			}
+		})
+	}
+}
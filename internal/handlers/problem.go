@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// problemContentType is the RFC 7807 error media type.
+const problemContentType = "application/problem+json"
+
+// issueTypeBase prefixes an OperationOutcome issue code to form a problem type URI.
+const issueTypeBase = "http://hl7.org/fhir/issue-type#"
+
+// ProblemJSON rewrites error OperationOutcomes (status >= 400) as RFC 7807
+// problem+json for gateways that don't speak FHIR: the first issue's code becomes
+// the problem type, its diagnostics the detail. Other responses pass through.
+func ProblemJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pw := &problemWriter{ResponseWriter: w}
+		next.ServeHTTP(pw, r)
+		if pw.buffering {
+			pw.flush()
+		}
+	})
+}
+
+// problemWriter buffers error responses carrying an OperationOutcome so they can be
+// converted; it decides at WriteHeader time and streams everything else.
+type problemWriter struct {
+	http.ResponseWriter
+	buffering bool
+	status    int
+	buf       bytes.Buffer
+	wrote     bool
+}
+
+func (pw *problemWriter) WriteHeader(status int) {
+	if pw.wrote {
+		return
+	}
+	pw.wrote = true
+	if status >= 400 && strings.HasPrefix(pw.Header().Get("Content-Type"), "application/fhir+json") {
+		pw.buffering, pw.status = true, status
+		return
+	}
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+func (pw *problemWriter) Write(b []byte) (int, error) {
+	if !pw.wrote {
+		pw.WriteHeader(http.StatusOK)
+	}
+	if pw.buffering {
+		return pw.buf.Write(b)
+	}
+	return pw.ResponseWriter.Write(b)
+}
+
+// flush converts the buffered OperationOutcome, or sends it unchanged if it isn't one.
+func (pw *problemWriter) flush() {
+	body := pw.buf.Bytes()
+	var oo struct {
+		ResourceType string `json:"resourceType"`
+		Issue        []struct {
+			Severity    string `json:"severity"`
+			Code        string `json:"code"`
+			Diagnostics string `json:"diagnostics"`
+		} `json:"issue"`
+	}
+	if err := json.Unmarshal(body, &oo); err != nil || oo.ResourceType != "OperationOutcome" || len(oo.Issue) == 0 {
+		pw.ResponseWriter.WriteHeader(pw.status)
+		_, _ = pw.ResponseWriter.Write(body)
+		return
+	}
+	issue := oo.Issue[0]
+	problem := map[string]any{
+		"type":     issueTypeBase + issue.Code,
+		"title":    http.StatusText(pw.status),
+		"status":   pw.status,
+		"detail":   issue.Diagnostics,
+		"severity": issue.Severity,
+	}
+	out, _ := json.Marshal(problem)
+	h := pw.Header()
+	h.Set("Content-Type", problemContentType)
+	h.Set("Content-Length", strconv.Itoa(len(out)))
+	pw.ResponseWriter.WriteHeader(pw.status)
+	_, _ = pw.ResponseWriter.Write(out)
+}
@@ -1,31 +1,224 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"awesomeProject/internal/auth"
 	"awesomeProject/internal/beclient"
+	"awesomeProject/internal/fhir"
 	"awesomeProject/internal/handlers"
 )
 
 func main() {
-	be := beclient.NewHTTPClient(
-		"https://dev.cloudsolutions.com.sa/csi-api/csi-net-empiread/api/patient",
-		15*time.Second,
-		true, // insecure TLS for dev, mirrors curl -k
-	)
-	deps := &handlers.PatientDeps{BE: be}
+	// FHIR_AUTH_ISSUER turns on SMART bearer token enforcement against that issuer.
+	issuer := envOr("FHIR_AUTH_ISSUER", "")
+	newBackend := func(baseURL string) *beclient.HTTPClient {
+		c := beclient.NewHTTPClient(
+			baseURL,
+			15*time.Second,
+			true, // insecure TLS for dev, mirrors curl -k
+			nil,  // default tenant X-* headers
+		)
+		c.UserAgent = envOr("FHIR_BE_USER_AGENT", c.UserAgent)
+		c.CorrelationHeader = envOr("FHIR_BE_CORRELATION_HEADER", c.CorrelationHeader)
+		c.AcceptLanguage = envOr("FHIR_BE_ACCEPT_LANGUAGE", "")
+		c.Lang = envOr("FHIR_BE_LANG", "")
+		// A SMART token is issued for this server and must not reach the BE; the BE
+		// credential then comes from FHIR_BE_AUTHORIZATION (e.g. "Bearer <token>").
+		c.ForwardAuthorization = issuer == ""
+		c.Authorization = envOr("FHIR_BE_AUTHORIZATION", "")
+		return c
+	}
+	// FHIR_BE_BASE_URLS lists backend endpoints in failover order (primary first).
+	baseURLs := strings.Split(envOr("FHIR_BE_BASE_URLS", "https://dev.cloudsolutions.com.sa/csi-api/csi-net-empiread/api/patient"), ",")
+	var be beclient.Client = newBackend(strings.TrimSpace(baseURLs[0]))
+	if len(baseURLs) > 1 {
+		for i := range baseURLs {
+			baseURLs[i] = strings.TrimSpace(baseURLs[i])
+		}
+		be = beclient.NewFailoverClient(baseURLs, newBackend)
+	}
+	// FHIR_STARTUP_PROBE opts in to checking the backend before serving; a failure
+	// only warns, so a backend outage doesn't keep the service from starting.
+	if envBool("FHIR_STARTUP_PROBE", false) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := be.Probe(ctx); err != nil {
+			log.Printf("WARN backend startup probe failed: %v", err)
+		} else {
+			log.Printf("Backend startup probe ok")
+		}
+		cancel()
+	}
+	// FHIR_BASE_PATH is where the FHIR routes are mounted; "/" mounts them at the root.
+	basePath := "/" + strings.Trim(envOr("FHIR_BASE_PATH", "/fhir"), "/")
+	transform := fhir.DefaultTransformOptions()
+	transform.MaxPhotoBytes = envInt("FHIR_MAX_PHOTO_BYTES", transform.MaxPhotoBytes)
+	if keys := envList("FHIR_BE_ENVELOPE_KEYS"); keys != nil {
+		transform.EnvelopeKeys = keys // e.g. data,details,result,patient
+	}
+	if placeholders := envList("FHIR_PLACEHOLDER_VALUES"); placeholders != nil {
+		transform.Placeholders = placeholders // e.g. -,null,N/A,none
+	}
+	transform.MaxIdentifiers = envInt("FHIR_MAX_IDENTIFIERS", transform.MaxIdentifiers)
+	transform.MaxTelecoms = envInt("FHIR_MAX_TELECOMS", transform.MaxTelecoms)
+	transform.MaxAddresses = envInt("FHIR_MAX_ADDRESSES", transform.MaxAddresses)
+	transform.GenderPrecedence = fhir.GenderPrecedence(envOr("FHIR_GENDER_PRECEDENCE", string(transform.GenderPrecedence)))
+	transform.ReferenceBase = envOr("FHIR_REFERENCE_BASE", "")
+	transform.SplitFullName = envBool("FHIR_SPLIT_FULL_NAME", false)
+	transform.PhoneRegion = envOr("FHIR_PHONE_REGION", "")
+	transform.IdentifierSystems = envMap("FHIR_IDENTIFIER_SYSTEMS") // e.g. national=2.16.840.1.113883.3.xxx,passport=urn:...
+	transform.SourceHashTag = envBool("FHIR_SOURCE_HASH_TAG", false)
+	transform.PreserveUnmapped = envBool("FHIR_PRESERVE_UNMAPPED", false)
+	transform.PhotoURLBase = basePath // oversized photos are served by GET <base>/Patient/{id}/photo
+	deps := &handlers.PatientDeps{
+		BE:                           be,
+		Transform:                    transform,
+		BasePath:                     basePath,
+		SlowRequestThreshold:         envDuration("FHIR_SLOW_REQUEST_THRESHOLD", 0),
+		DebugOperations:              envBool("FHIR_DEBUG_OPERATIONS", false),
+		PassthroughBackendAuthErrors: envBool("FHIR_BE_AUTH_PASSTHROUGH", false),
+		Redaction: fhir.Redaction{
+			Elements:          envList("FHIR_REDACT_ELEMENTS"),           // e.g. telecom,address,contact
+			IdentifierSystems: envList("FHIR_REDACT_IDENTIFIER_SYSTEMS"), // e.g. urn:national
+		},
+	}
+
+	if deps.DebugOperations && issuer == "" {
+		log.Printf("WARN FHIR_DEBUG_OPERATIONS needs FHIR_AUTH_ISSUER: debug operations require an %s token and will refuse every request", auth.DebugScope)
+	}
+
+	handler := handlers.Routes(deps)
+	if envBool("FHIR_REJECT_BODY_ON_GET", true) {
+		handler = handlers.RejectBodyOnGetDelete(handler)
+	}
+	if issuer != "" {
+		jwksURL := envOr("FHIR_AUTH_JWKS_URL", strings.TrimRight(issuer, "/")+"/.well-known/jwks.json")
+		keys := auth.NewKeySet(jwksURL, 5*time.Second)
+		keys.TTL = envDuration("FHIR_AUTH_JWKS_TTL", keys.TTL)
+		keys.MinRefreshInterval = envDuration("FHIR_AUTH_JWKS_MIN_REFRESH", keys.MinRefreshInterval)
+		handler = handlers.SMARTAuth(&auth.Verifier{
+			Issuer:   issuer,
+			Audience: envOr("FHIR_AUTH_AUDIENCE", ""),
+			Keys:     keys,
+			Leeway:   envDuration("FHIR_AUTH_LEEWAY", 30*time.Second),
+		}, handler)
+	}
+	handler = handlers.Pretty(envBool("FHIR_PRETTY_JSON", false), handler)
+	handler = handlers.WithTimeout(envDuration("FHIR_REQUEST_TIMEOUT", 12*time.Second), handler)
+	handler = handlers.Recover(handler)
+	// FHIR_ERROR_FORMAT=problem+json answers errors as RFC 7807 instead of OperationOutcome;
+	// it wraps Recover so panics are converted too.
+	if strings.EqualFold(envOr("FHIR_ERROR_FORMAT", "operationoutcome"), "problem+json") {
+		handler = handlers.ProblemJSON(handler)
+	}
+	handler = handlers.RequestID(handler)
+
+	// FHIR_EXPOSE_METRICS serves expvar counters (data-quality included) on /debug/vars.
+	if envBool("FHIR_EXPOSE_METRICS", false) {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", expvar.Handler())
+		mux.Handle("/", handler)
+		handler = mux
+	}
 
 	srv := &http.Server{
 		Addr:         ":8080",
-		Handler:      handlers.Routes(deps),
+		Handler:      handler,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
-	log.Println("FHIR proxy listening on :8080 (GET /fhir/Patient/{id})")
+	log.Printf("FHIR proxy listening on :8080 (GET %[1]s/Patient/{id}, GET %[1]s/Patient/{id}/photo)", strings.TrimSuffix(basePath, "/"))
 	if err := srv.ListenAndServe(); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// envOr reads a string setting from the environment, falling back to def when unset.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt reads an integer setting from the environment, falling back to def when unset or invalid.
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("ignoring invalid %s=%q: %v", key, v, err)
+		return def
+	}
+	return n
+}
+
+// envBool reads a boolean setting from the environment, falling back to def when unset or invalid.
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("ignoring invalid %s=%q: %v", key, v, err)
+		return def
+	}
+	return b
+}
+
+// envDuration reads a time.Duration setting (e.g. "500ms") from the environment,
+// falling back to def when unset or invalid.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("ignoring invalid %s=%q: %v", key, v, err)
+		return def
+	}
+	return d
+}
+
+// envList reads a comma-separated list from the environment, skipping blank items.
+func envList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envMap reads comma-separated key=value pairs (keys lower-cased) from the
+// environment. Malformed pairs are logged and skipped; unset yields nil.
+func envMap(key string) map[string]string {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	m := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		k, val, ok := strings.Cut(pair, "=")
+		k, val = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(val)
+		if !ok || k == "" || val == "" {
+			log.Printf("ignoring invalid %s entry %q", key, pair)
+			continue
+		}
+		m[k] = val
+	}
+	return m
+}